@@ -2,83 +2,59 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/joho/godotenv"
 
 	khandler "feedback-service-go/handlers/kafka"
-	mysql "feedback-service-go/repositories/mysql"
-
-	kafka "github.com/segmentio/kafka-go"
+	"feedback-service-go/repositories/storage"
 )
 
 func main() {
 	log.Println("Start kafka consumer server")
 
-	err := godotenv.Load(".env")
+	// the settings may come from the environment only, so a missing .env is fine
+	godotenv.Load(".env")
+
+	repository, err := storage.New()
 	if err != nil {
 		panic(err.Error())
 	}
+	defer repository.Close()
+	log.Println("Kafka consumer server successfully connected to the storage")
 
-	topicName := os.Getenv("KAFKA_TOPIC_NAME")
-	// topicGroupId := os.Getenv("KAFKA_GROUP_ID")
-	topicBrokers := os.Getenv("KAFKA_BROKER_ADDRESS")
+	reader, err := khandler.NewReader()
+	if err != nil {
+		panic(err.Error())
+	}
+	defer reader.Close()
 
-	repository, err := mysql.New()
+	deadLetters, err := khandler.NewDeadLetterWriter()
 	if err != nil {
 		panic(err.Error())
 	}
-	log.Println("Kafka consumer server successfully connected to the storage")
+	defer deadLetters.Close()
 
-	ctx := context.Background()
+	workers, err := khandler.Workers()
+	if err != nil {
+		panic(err.Error())
+	}
 
-	// create a new logger that outputs to stdout
-	// and has the `kafka reader` prefix
-	l := log.New(os.Stdout, "kafka reader: ", 0)
-	// initialize a new reader with the brokers and topic
-	// the groupID identifies the consumer and prevents
-	// it from receiving duplicate messages
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{topicBrokers},
-		Topic:   topicName,
-		// GroupID:     topicGroupId,
-		Logger:      l,
-		MaxWait:     time.Duration(10000000000),
-		MaxAttempts: 10,
-	})
-	for {
-		// the `ReadMessage` method blocks until we receive the next event
-		rawMsg, err := r.ReadMessage(ctx)
-		if err != nil {
-			panic("could not read message " + err.Error())
-		}
+	retry, err := khandler.NewBackoff()
+	if err != nil {
+		panic(err.Error())
+	}
 
-		var inputRequest khandler.KafkaRequest
-		err = json.Unmarshal(rawMsg.Value, &inputRequest)
-		if err != nil {
-			panic(err.Error())
-		}
-		fmt.Println("sucessfully got from Kafka:", string(rawMsg.Value))
+	// the message in progress is left uncommitted on shutdown and handled again on the next start
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		switch inputRequest.Action {
-		case "create-action":
-			// TODO: check for inputRequest.Version
-			go khandler.CreateFeedback(inputRequest.Payload, repository)
-		case "update-action":
-			// TODO: check for inputRequest.Version
-			go khandler.UpdateFeedback(inputRequest.Payload, repository)
-		case "delete-offer-action":
-			// TODO: check for inputRequest.Version
-			go khandler.DeleteOffer(inputRequest.Payload, repository)
-		case "change-trade-status-action":
-			// TODO: check for inputRequest.Version
-			go khandler.ChangeTradeStatus(inputRequest.Payload, repository)
-		default:
-			fmt.Println("got unknown action:", inputRequest.Action)
-		}
+	err = khandler.Consume(ctx, reader, deadLetters, repository, workers, retry)
+	if err != nil {
+		log.Fatal(err)
 	}
+	log.Println("Kafka consumer server stopped")
 }
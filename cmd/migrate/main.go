@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+
+	"feedback-service-go/repositories/storage"
+)
+
+const usage = "usage: migrate up | down [steps] | status"
+
+// migrate applies, reverts or lists the schema migrations of the storage
+// configured the same way as the servers
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+
+	// the settings may come from the environment only, so a missing .env is fine
+	godotenv.Load(".env")
+
+	storageName := os.Getenv("STORAGE")
+	repo, err := storage.Open(storageName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repo.Close()
+
+	migrator, err := storage.NewMigrator(storageName, repo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if migrator == nil {
+		log.Println("the in-memory storage has nothing to migrate")
+		return
+	}
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("applied %d migrations", len(applied))
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			steps, err = strconv.Atoi(os.Args[2])
+			if err != nil || steps < 1 {
+				log.Fatal(usage)
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("reverted %d migrations", len(reverted))
+	case "status":
+		list, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, status := range list {
+			state := "pending"
+			if status.Applied {
+				state = "applied at " + status.AppliedAt
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, state)
+		}
+	default:
+		log.Fatal(usage)
+	}
+}
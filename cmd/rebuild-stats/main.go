@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	repository "feedback-service-go/repositories"
+	"feedback-service-go/repositories/storage"
+)
+
+// rebuild-stats recounts feedback_stats from the feedbacks and prints every
+// user whose counters were wrong
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report the drifted counters")
+	batchSize := flag.Int("batch-size", repository.DefaultStatsBatchSize, "users fixed per transaction")
+	flag.Parse()
+
+	if *batchSize < 1 {
+		log.Fatal("batch-size must be positive")
+	}
+
+	repo, err := storage.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repo.Close()
+
+	diffs, err := repo.RebuildStats(context.Background(), &repository.RebuildStatsRequest{DryRun: *dryRun, BatchSize: *batchSize})
+	for _, diff := range diffs {
+		fmt.Printf("%s\tpositive %d -> %d\tnegative %d -> %d\n", diff.UserUuid, diff.Stored.Positive, diff.Expected.Positive, diff.Stored.Negative, diff.Expected.Negative)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *dryRun {
+		log.Printf("%d users have drifted counters", len(diffs))
+	} else {
+		log.Printf("fixed the counters of %d users", len(diffs))
+	}
+}
@@ -7,22 +7,40 @@ import (
 	"github.com/gorilla/mux"
 
 	rhandler "feedback-service-go/handlers/rest"
-	mysql "feedback-service-go/repositories/mysql"
+	repository "feedback-service-go/repositories"
+	"feedback-service-go/repositories/storage"
 )
 
 func main() {
 	log.Println("Start rest server")
 
-	repository, err := mysql.New()
+	repo, err := storage.New()
 	if err != nil {
 		panic(err.Error())
 	}
 	log.Println("REST successfully connected to the storage")
 
-	restHandler := rhandler.New(repository)
+	router := newRouter(repo)
+	log.Fatal(http.ListenAndServe(":8080", router))
+}
+
+func newRouter(repo repository.Repository) *mux.Router {
+	restHandler := rhandler.New(repo)
 
 	router := mux.NewRouter().StrictSlash(true)
+	router.Use(rhandler.Recoverer)
+	router.NotFoundHandler = http.HandlerFunc(rhandler.NotFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(rhandler.MethodNotAllowed)
 	router.HandleFunc("/feedback/{id}", restHandler.GetFeedback).Methods("GET")
+	router.HandleFunc("/feedback/{id}", restHandler.UpdateFeedback).Methods("PATCH")
+	router.HandleFunc("/feedback/{id}", restHandler.DeleteFeedback).Methods("DELETE")
+	router.HandleFunc("/feedback/{id}/replies", restHandler.GetFeedbackReplies).Methods("GET")
+	router.HandleFunc("/feedback/{id}/restore", restHandler.RestoreFeedback).Methods("POST")
 	router.HandleFunc("/feedbacks", restHandler.GetFeedbacksByFilter).Methods("GET")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	router.HandleFunc("/feedbacks", restHandler.CreateFeedback).Methods("POST")
+	// deprecated alias of POST /feedbacks
+	router.HandleFunc("/feedback", restHandler.CreateFeedback).Methods("POST")
+	router.HandleFunc("/users/{uuid}/stats", restHandler.GetUserStats).Methods("GET")
+
+	return router
 }
@@ -2,23 +2,30 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
 	"encoding/json"
-	mysql "feedback-service-go/repositories/mysql"
+	repository "feedback-service-go/repositories"
+	memory "feedback-service-go/repositories/memory"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"regexp"
-	"strings"
 	"testing"
 	"time"
 )
 
+const createRequestTemplate = `{"parent_id":%d,"sender_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b136","sender_name":"sender#1","sender_avatar":"sender#1 avatar","receiver_uuid":"%s","receiver_name":"receiver#1","receiver_avatar":"receiver#1 avatar","offer_hash":"ksO3jso7aDi","offer_authorized":true,"offer_owner_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b138","offer_type":"SELL","offer_payment_method":"PayPal","offer_payment_method_slug":"paypal_slug","offer_fiat_code":"RUB","offer_crypto_code":"BTC","trade_hash":"isO9AlIU8s2","trade_fiat_amount_requested_in_usd":"320.12","trade_status":"RELEASED","message":"%s","feedback_type":"%s","created_at":"%s"}`
+
+const (
+	receiver1 = "807a51d6-a81b-4b66-9596-5b17ea26b137"
+	receiver2 = "807a51d6-a81b-4b66-9596-5b17ea26b139"
+)
+
 func TestGetEmptyFeedbackList(t *testing.T) {
-	resetDatabase()
+	url := startServer(t)
 
-	jsonResponse := getBody("GET", "http://app:8080/feedbacks", nil, http.StatusOK)
+	jsonResponse := getBody("GET", url+"/feedbacks", nil, http.StatusOK)
 
 	expected := `{"items":[],"limit":10,"offset":0,"total":0}`
 	if expected != string(jsonResponse) {
@@ -26,112 +33,614 @@ func TestGetEmptyFeedbackList(t *testing.T) {
 	}
 }
 
+func TestErrorResponses(t *testing.T) {
+	url := startServer(t)
+
+	jsonResponse := getBody("GET", url+"/unknown", nil, http.StatusNotFound)
+
+	expected := `{"code":"not_found","message":"The resource is not found!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+
+	jsonResponse = getBody("PUT", url+"/feedbacks", nil, http.StatusMethodNotAllowed)
+
+	expected = `{"code":"method_not_allowed","message":"The method is not allowed!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+
+	jsonResponse = getBody("GET", url+"/feedback/1", nil, http.StatusNotFound)
+
+	expected = `{"code":"not_found","message":"The resource is not found!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+
+	id := createFeedback(url, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")
+	resp := sendRequest("DELETE", url+"/feedback/"+id, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Content-Type") != "" {
+		t.Errorf("Bad response: %d with the Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
 func TestCreateFeedback(t *testing.T) {
-	resetDatabase()
+	url := startServer(t)
 
-	var requestJson = []byte(`{"sender_id":1,"parent_id":1,"receiver_id":2,"trade_id":1,"message":"text message","type":"positive","created_at":"2021-09-06 05:01:43"}`)
-	jsonResponse := getBody("POST", "http://app:8080/feedback", bytes.NewBuffer(requestJson), http.StatusCreated)
+	var requestJson = []byte(fmt.Sprintf(createRequestTemplate, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43"))
+	resp := sendRequest("POST", url+"/feedbacks", bytes.NewBuffer(requestJson))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("invalid status code! %d (%s)", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	if !regexp.MustCompile(`^/feedback/\d+$`).MatchString(resp.Header.Get("Location")) {
+		t.Errorf("Bad Location header: %v", resp.Header.Get("Location"))
+	}
+	resp.Body.Close()
 
-	re, err := regexp.Compile(`{"Message":"text message","Type":"positive","created_at":"2021-09-06 05:01:43","deleted_at":null,"id":\d+,"parent_id":1,"receiver_id":2,"sender_id":1,"trade_id":1,"updated_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}"}`)
-	if err != nil {
-		t.Errorf(err.Error())
+	// the deprecated alias still creates feedbacks
+	jsonResponse := getBody("POST", url+"/feedback", bytes.NewBuffer(requestJson), http.StatusCreated)
+
+	re := regexp.MustCompile(`^{"created_at":"2021-09-06 05:01:43","deleted_at":null,"feedback_type":"POSITIVE","id":2,"message":"text message","offer_authorized":true,"offer_crypto_code":"BTC","offer_deleted_at":null,"offer_fiat_code":"RUB","offer_hash":"ksO3jso7aDi","offer_owner_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b138","offer_payment_method":"PayPal","offer_payment_method_slug":"paypal_slug","offer_type":"SELL","parent_id":null,"receiver_avatar":"receiver#1 avatar","receiver_name":"receiver#1","receiver_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b137","sender_avatar":"sender#1 avatar","sender_name":"sender#1","sender_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b136","trade_fiat_amount_requested_in_usd":"320.12","trade_hash":"isO9AlIU8s2","trade_status":"RELEASED","updated_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}"}$`)
+	if !re.MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+}
+
+func TestCreateInvalidFeedback(t *testing.T) {
+	url := startServer(t)
+
+	var requestJson = []byte(fmt.Sprintf(createRequestTemplate, 0, "not-a-uuid", "", "NEUTRAL", "yesterday"))
+	jsonResponse := getBody("POST", url+"/feedbacks", bytes.NewBuffer(requestJson), http.StatusBadRequest)
+
+	expected := `{"code":"validation_failed","details":{"created_at":["The created_at field must be formatted as '2006-01-02 15:04:05'!"],"feedback_type":["The feedback_type field must be one of POSITIVE, NEGATIVE!"],"message":["The message field is required!"],"receiver_uuid":["The receiver_uuid field is required and must be a UUID!"]},"message":"The request is invalid!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
 	}
 
-	found := re.MatchString(string(jsonResponse))
-	if !found {
-		t.Errorf(err.Error())
+	jsonResponse = getBody("POST", url+"/feedbacks", bytes.NewBufferString("{"), http.StatusBadRequest)
+	if !regexp.MustCompile(`^{"code":"bad_request",`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
 	}
 }
 
 func TestUpdateFeedback(t *testing.T) {
-	resetDatabase()
+	url := startServer(t)
 
-	var requestJson = []byte(`{"sender_id":1,"receiver_id":2,"trade_id":1,"message":"text message","type":"positive"}`)
-	jsonResponse := getBody("POST", "http://app:8080/feedback", bytes.NewBuffer(requestJson), http.StatusCreated)
-	re, err := regexp.Compile(`{"Message":"text message","Type":"positive","created_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}","deleted_at":null,"id":(\d+),"parent_id":null,"receiver_id":2,"sender_id":1,"trade_id":1,"updated_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}"}`)
-	if err != nil {
-		t.Errorf(err.Error())
+	id := createFeedback(url, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")
+
+	var requestJson = []byte(`{"message":"text message new","feedback_type":"NEGATIVE"}`)
+	getBody("PATCH", url+"/feedback/"+id, bytes.NewBuffer(requestJson), http.StatusNoContent)
+	getBody("PATCH", url+"/feedback/100", bytes.NewBuffer(requestJson), http.StatusNotFound)
+
+	jsonResponse := getBody("GET", url+"/feedbacks", nil, http.StatusOK)
+
+	re := regexp.MustCompile(`^{"items":\[{"created_at":"2021-09-06 05:01:43","deleted_at":null,"feedback_type":"NEGATIVE","id":1,"message":"text message new",.*}\],"limit":10,"offset":0,"total":1}$`)
+	if !re.MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
 	}
 
-	match := re.FindStringSubmatch(string(jsonResponse))
-	id := match[1]
-	requestJson = []byte(`{"sender_id":111,"receiver_id":222,"trade_id":333,"message":"text message new","type":"negative"}`)
-	getBody("PATCH", "http://app:8080/feedback/"+id, bytes.NewBuffer(requestJson), http.StatusNoContent)
+	jsonResponse = getBody("GET", url+"/users/"+receiver1+"/stats", nil, http.StatusOK)
 
-	jsonResponse = getBody("GET", "http://app:8080/feedbacks", nil, http.StatusOK)
+	expected := `{"initial":0,"negative":1,"positive":0,"positive_percentage":0,"total":1,"user_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b137"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+}
 
-	re, err = regexp.Compile(`{"items":\[{"Message":"text message new","Type":"negative","created_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}","deleted_at":null,"id":1,"parent_id":null,"receiver_id":222,"sender_id":111,"trade_id":333,"updated_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}"}\],"limit":10,"offset":0,"total":1}`)
-	if err != nil {
-		t.Errorf(err.Error())
+func TestUpdateFeedbackPartially(t *testing.T) {
+	url := startServer(t)
+
+	id := createFeedback(url, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")
+
+	getBody("PATCH", url+"/feedback/"+id, bytes.NewBufferString(`{"message":"only the message"}`), http.StatusNoContent)
+
+	jsonResponse := getBody("GET", url+"/feedback/"+id, nil, http.StatusOK)
+	if !regexp.MustCompile(`"feedback_type":"POSITIVE","id":1,"message":"only the message",`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
 	}
 
-	found := re.MatchString(string(jsonResponse))
-	if !found {
-		t.Errorf(err.Error())
+	getBody("PATCH", url+"/feedback/"+id, bytes.NewBufferString(`{"feedback_type":"NEGATIVE"}`), http.StatusNoContent)
+
+	jsonResponse = getBody("GET", url+"/feedback/"+id, nil, http.StatusOK)
+	if !regexp.MustCompile(`"feedback_type":"NEGATIVE","id":1,"message":"only the message",`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+}
+
+func TestUpdateInvalidFeedback(t *testing.T) {
+	url := startServer(t)
+
+	id := createFeedback(url, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")
+
+	jsonResponse := getBody("PATCH", url+"/feedback/"+id, bytes.NewBufferString(`{"feedback_type":"NEUTRAL"}`), http.StatusBadRequest)
+
+	expected := `{"code":"validation_failed","details":{"feedback_type":["The feedback_type field must be one of POSITIVE, NEGATIVE!"]},"message":"The request is invalid!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+
+	jsonResponse = getBody("PATCH", url+"/feedback/"+id, bytes.NewBufferString(`{}`), http.StatusBadRequest)
+
+	expected = `{"code":"validation_failed","details":{"message":["Either the message or the feedback_type field is required!"]},"message":"The request is invalid!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+
+	jsonResponse = getBody("PATCH", url+"/feedback/"+id, bytes.NewBufferString("{"), http.StatusBadRequest)
+	if !regexp.MustCompile(`^{"code":"bad_request",`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+
+	getBody("PATCH", url+"/feedback/abc", bytes.NewBufferString(`{"message":"new"}`), http.StatusBadRequest)
+
+	// a trashed feedback can't be edited
+	getBody("DELETE", url+"/feedback/"+id, nil, http.StatusNoContent)
+	getBody("PATCH", url+"/feedback/"+id, bytes.NewBufferString(`{"message":"new"}`), http.StatusNotFound)
+
+	jsonResponse = getBody("GET", url+"/feedback/"+id+"/replies?with_trashed=1", nil, http.StatusOK)
+	if !regexp.MustCompile(`"feedback_type":"POSITIVE","id":1,"message":"text message",`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+}
+
+func TestGetUserStats(t *testing.T) {
+	url := startServer(t)
+
+	jsonResponse := getBody("GET", url+"/users/"+receiver1+"/stats", nil, http.StatusOK)
+
+	expected := `{"initial":0,"negative":0,"positive":0,"positive_percentage":0,"total":0,"user_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b137"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+
+	createFeedback(url, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")
+	createFeedback(url, 0, receiver1, "text message2", "POSITIVE", "2021-09-07 05:01:43")
+	createFeedback(url, 0, receiver1, "text message3", "NEGATIVE", "2021-09-08 05:01:43")
+	createFeedback(url, 0, receiver2, "text message4", "NEGATIVE", "2021-09-09 05:01:43")
+
+	jsonResponse = getBody("GET", url+"/users/"+receiver1+"/stats", nil, http.StatusOK)
+
+	expected = `{"initial":0,"negative":1,"positive":2,"positive_percentage":66.67,"total":3,"user_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b137"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+
+	jsonResponse = getBody("GET", url+"/users/not-a-uuid/stats", nil, http.StatusBadRequest)
+
+	expected = `{"code":"validation_failed","details":{"uuid":["The uuid must be a UUID!"]},"message":"The request is invalid!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
 	}
 }
 
 func TestDeleteFeedback(t *testing.T) {
-	resetDatabase()
+	url := startServer(t)
 
 	// create the first feedback
-	var requestJson = []byte(`{"sender_id":1,"receiver_id":2,"trade_id":1,"message":"text message","type":"positive"}`)
-	jsonResponse := getBody("POST", "http://app:8080/feedback", bytes.NewBuffer(requestJson), http.StatusCreated)
-	re, err := regexp.Compile(`{"Message":"text message","Type":"positive","created_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}","deleted_at":null,"id":(\d+),"parent_id":null,"receiver_id":2,"sender_id":1,"trade_id":1,"updated_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}"}`)
-	if err != nil {
-		t.Errorf(err.Error())
-	}
-	match := re.FindStringSubmatch(string(jsonResponse))
-	id := match[1]
+	id := createFeedback(url, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")
 
 	// create the second one
-	requestJson = []byte(`{"sender_id":1,"receiver_id":3,"trade_id":2,"message":"text message2","type":"negative"}`)
-	getBody("POST", "http://app:8080/feedback", bytes.NewBuffer(requestJson), http.StatusCreated)
+	createFeedback(url, 0, receiver2, "text message2", "NEGATIVE", "2021-09-07 05:01:43")
 
 	// delete the first one
-	getBody("DELETE", fmt.Sprintf("http://app:8080/feedback/%s", id), nil, http.StatusNoContent)
+	getBody("DELETE", fmt.Sprintf("%s/feedback/%s", url, id), nil, http.StatusNoContent)
+	getBody("DELETE", fmt.Sprintf("%s/feedback/%s", url, id), nil, http.StatusNotFound)
+	getBody("GET", fmt.Sprintf("%s/feedback/%s", url, id), nil, http.StatusNotFound)
 
 	// check the result
-	jsonResponse = getBody("GET", "http://app:8080/feedbacks", nil, http.StatusOK)
+	jsonResponse := getBody("GET", url+"/feedbacks", nil, http.StatusOK)
 
-	re, err = regexp.Compile(`{"items":\[{"Message":"text message2","Type":"negative","created_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}","deleted_at":null,"id":\d+,"parent_id":null,"receiver_id":3,"sender_id":1,"trade_id":2,"updated_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}"}\],"limit":10,"offset":0,"total":1}`)
-	if err != nil {
-		t.Errorf(err.Error())
+	re := regexp.MustCompile(`^{"items":\[{"created_at":"2021-09-07 05:01:43","deleted_at":null,"feedback_type":"NEGATIVE","id":2,"message":"text message2",.*}\],"limit":10,"offset":0,"total":1}$`)
+	if !re.MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
 	}
-	found := re.MatchString(string(jsonResponse))
-	if !found {
-		t.Errorf(err.Error())
+
+	jsonResponse = getBody("GET", url+"/users/"+receiver1+"/stats", nil, http.StatusOK)
+	if !regexp.MustCompile(`"positive":0,`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+
+	// restore it back
+	getBody("POST", fmt.Sprintf("%s/feedback/%s/restore", url, id), nil, http.StatusNoContent)
+	getBody("POST", fmt.Sprintf("%s/feedback/%s/restore", url, id), nil, http.StatusNotFound)
+
+	jsonResponse = getBody("GET", url+"/users/"+receiver1+"/stats", nil, http.StatusOK)
+	if !regexp.MustCompile(`"positive":1,`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
 	}
 }
 
-func resetDatabase() {
-	repo, err := mysql.New()
-	if err != nil {
-		panic(err.Error())
+func TestDeleteAndRestoreMissingFeedback(t *testing.T) {
+	url := startServer(t)
+
+	id := createFeedback(url, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")
+
+	getBody("DELETE", url+"/feedback/100", nil, http.StatusNotFound)
+	getBody("DELETE", url+"/feedback/0", nil, http.StatusBadRequest)
+	getBody("POST", url+"/feedback/100/restore", nil, http.StatusNotFound)
+	getBody("POST", url+"/feedback/abc/restore", nil, http.StatusBadRequest)
+
+	// only a trashed feedback can be restored
+	getBody("POST", url+"/feedback/"+id+"/restore", nil, http.StatusNotFound)
+
+	jsonResponse := getBody("GET", url+"/users/"+receiver1+"/stats", nil, http.StatusOK)
+	if !regexp.MustCompile(`"positive":1,`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+}
+
+func TestGetTrashedFeedbacks(t *testing.T) {
+	url := startServer(t)
+
+	id := createFeedback(url, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")
+	getBody("DELETE", url+"/feedback/"+id, nil, http.StatusNoContent)
+
+	jsonResponse := getBody("GET", url+"/feedbacks", nil, http.StatusOK)
+
+	expected := `{"items":[],"limit":10,"offset":0,"total":0}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
 	}
 
-	loadSQLFile(repo.GetDB(), "init_test.sql")
+	jsonResponse = getBody("GET", url+"/feedbacks?with_trashed=1", nil, http.StatusOK)
+
+	re := regexp.MustCompile(`^{"items":\[{"created_at":"2021-09-06 05:01:43","deleted_at":"\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}","feedback_type":"POSITIVE","id":1,.*}\],"limit":10,"offset":0,"total":1}$`)
+	if !re.MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
 }
 
-func loadSQLFile(db *sql.DB, sqlFile string) error {
-	file, err := ioutil.ReadFile(sqlFile)
-	if err != nil {
-		return err
+func TestGetFeedbacksByFilter(t *testing.T) {
+	url := startServer(t)
+
+	createFeedbackWith(url, map[string]interface{}{"message": "first", "created_at": "2021-09-01 05:01:43", "trade_fiat_amount_requested_in_usd": "100.00"})
+	createFeedbackWith(url, map[string]interface{}{"message": "second", "created_at": "2021-09-02 05:01:43", "trade_fiat_amount_requested_in_usd": "500.00", "feedback_type": "NEGATIVE", "offer_fiat_code": "EUR"})
+	createFeedbackWith(url, map[string]interface{}{"message": "third", "created_at": "2021-09-03 05:01:43", "trade_fiat_amount_requested_in_usd": "900.00", "offer_fiat_code": "USD", "receiver_uuid": receiver2})
+
+	for query, expected := range map[string]string{
+		"feedback_type=NEGATIVE":                               `["second"]`,
+		"feedback_type=POSITIVE,NEGATIVE":                      `["third","second","first"]`,
+		"offer_fiat_code=EUR&offer_fiat_code=USD":              `["third","second"]`,
+		"offer_fiat_code=EUR,+USD":                             `["third","second"]`,
+		"receiver_uuid=" + receiver2:                           `["third"]`,
+		"created_from=2021-09-02":                              `["third","second"]`,
+		"created_to=2021-09-02+05:01:43":                       `["first"]`,
+		"created_from=2021-09-02&created_to=2021-09-03":        `["second"]`,
+		"amount_min=500":                                       `["third","second"]`,
+		"amount_max=500.00":                                    `["second","first"]`,
+		"amount_min=200&amount_max=600&feedback_type=NEGATIVE": `["second"]`,
+		"feedback_type=NEGATIVE&offer_fiat_code=RUB":           `[]`,
+	} {
+		actual := getMessages(url + "/feedbacks?" + query)
+		if expected != actual {
+			t.Errorf("Bad response for %s! Expected: %v, extual: %v", query, expected, actual)
+		}
 	}
-	tx, err := db.Begin()
-	if err != nil {
-		return err
+}
+
+func TestGetFeedbacksByInvalidFilter(t *testing.T) {
+	url := startServer(t)
+
+	jsonResponse := getBody("GET", url+"/feedbacks?parent_id=-1&created_from=yesterday&amount_min=abc&amount_max=1e", nil, http.StatusBadRequest)
+
+	expected := `{"code":"validation_failed","details":{"amount_max":["The amount_max field must be a decimal with up to 8 integer and 2 fractional digits!"],"amount_min":["The amount_min field must be a decimal with up to 8 integer and 2 fractional digits!"],"created_from":["The created_from field must be formatted as '2006-01-02' or '2006-01-02 15:04:05'!"],"parent_id":["The parent_id field must be a positive number!"]},"message":"The request is invalid!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+
+	for _, amount := range []string{"NaN", "Inf", "1e5", "-10", "320.123", "123456789"} {
+		getBody("GET", url+"/feedbacks?amount_min="+amount, nil, http.StatusBadRequest)
+		getBody("GET", url+"/feedbacks?amount_max="+amount, nil, http.StatusBadRequest)
+	}
+
+	jsonResponse = getBody("GET", url+"/feedbacks?amount_min=500&amount_max=90.50", nil, http.StatusBadRequest)
+
+	expected = `{"code":"validation_failed","details":{"amount_min":["The amount_min field must not be greater than the amount_max one!"]},"message":"The request is invalid!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+
+	getBody("GET", url+"/feedbacks?amount_min=90.50&amount_max=90.50", nil, http.StatusOK)
+}
+
+func TestGetFeedbacksSorted(t *testing.T) {
+	url := startServer(t)
+
+	createFeedbackWith(url, map[string]interface{}{"message": "first", "created_at": "2021-09-01 05:01:43", "trade_fiat_amount_requested_in_usd": "500.00"})
+	createFeedbackWith(url, map[string]interface{}{"message": "second", "created_at": "2021-09-02 05:01:43", "trade_fiat_amount_requested_in_usd": "90.00", "feedback_type": "NEGATIVE"})
+	createFeedbackWith(url, map[string]interface{}{"message": "third", "created_at": "2021-09-03 05:01:43", "trade_fiat_amount_requested_in_usd": "100.00"})
+
+	for query, expected := range map[string]string{
+		"":                     `["third","second","first"]`,
+		"sort=created_at":      `["first","second","third"]`,
+		"sort=-created_at":     `["third","second","first"]`,
+		"sort=amount":          `["second","third","first"]`,
+		"sort=-amount":         `["first","third","second"]`,
+		"sort=feedback_type":   `["second","first","third"]`,
+		"sort=-amount&limit=1": `["first"]`,
+		"sort=amount&offset=1": `["third","first"]`,
+	} {
+		actual := getMessages(url + "/feedbacks?" + query)
+		if expected != actual {
+			t.Errorf("Bad response for %s! Expected: %v, extual: %v", query, expected, actual)
+		}
+	}
+
+	jsonResponse := getBody("GET", url+"/feedbacks?sort=sender_name", nil, http.StatusBadRequest)
+
+	expected := `{"code":"validation_failed","details":{"sort":["The sort field must be one of 'created_at', 'updated_at', 'amount', 'feedback_type' or 'relevance' optionally prefixed by '-'!"]},"message":"The request is invalid!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+	}
+
+	getBody("GET", url+"/feedbacks?sort=--amount", nil, http.StatusBadRequest)
+	getBody("GET", url+"/feedbacks?sort=relevance", nil, http.StatusBadRequest)
+}
+
+func TestGetFeedbacksByQuery(t *testing.T) {
+	url := startServer(t)
+
+	createFeedbackWith(url, map[string]interface{}{"message": "fast trade", "created_at": "2021-09-01 05:01:43"})
+	createFeedbackWith(url, map[string]interface{}{"message": "Scam, scam and scam", "created_at": "2021-09-02 05:01:43"})
+	createFeedbackWith(url, map[string]interface{}{"message": "looks like a scam, slow trade", "created_at": "2021-09-03 05:01:43"})
+
+	for query, expected := range map[string]string{
+		"q=SCAM":                 `["Scam, scam and scam","looks like a scam, slow trade"]`,
+		"q=scam+slow":            `["Scam, scam and scam","looks like a scam, slow trade"]`,
+		"q=trade":                `["looks like a scam, slow trade","fast trade"]`,
+		"q=scam&sort=created_at": `["Scam, scam and scam","looks like a scam, slow trade"]`,
+		"q=refund":               `[]`,
+		"q=+++":                  `["looks like a scam, slow trade","Scam, scam and scam","fast trade"]`,
+	} {
+		actual := getMessages(url + "/feedbacks?" + query)
+		if expected != actual {
+			t.Errorf("Bad response for %s! Expected: %v, extual: %v", query, expected, actual)
+		}
+	}
+
+	jsonResponse := getBody("GET", url+"/feedbacks?q=scam&limit=1", nil, http.StatusOK)
+	if !regexp.MustCompile(`"total":2`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+
+	cursor := repository.NewCursor(repository.DefaultSort, &repository.Feedback{ID: 1, CreatedAt: "2021-09-01 05:01:43"}).Encode()
+	getBody("GET", url+"/feedbacks?q=scam&cursor="+cursor, nil, http.StatusBadRequest)
+}
+
+func TestGetFeedbacksByMalformedIdentifiers(t *testing.T) {
+	url := startServer(t)
+
+	for name, message := range map[string]string{
+		"sender_uuid":   "The sender_uuid field must be a UUID!",
+		"receiver_uuid": "The receiver_uuid field must be a UUID!",
+		"offer_hash":    "The offer_hash field must be 11 alphanumeric chars!",
+		"trade_hash":    "The trade_hash field must be 11 alphanumeric chars!",
+	} {
+		jsonResponse := getBody("GET", url+"/feedbacks?"+name+"=807a51d6'%20OR%201=1", nil, http.StatusBadRequest)
+
+		expected := `{"code":"validation_failed","details":{"` + name + `":["` + message + `"]},"message":"The request is invalid!"}`
+		if expected != string(jsonResponse) {
+			t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
+		}
+	}
+
+	createFeedback(url, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")
+
+	for _, query := range []string{
+		"sender_uuid=807a51d6-a81b-4b66-9596-5b17ea26b136",
+		"receiver_uuid=" + receiver1,
+		"offer_hash=ksO3jso7aDi",
+		"trade_hash=isO9AlIU8s2",
+	} {
+		if actual := getMessages(url + "/feedbacks?" + query); actual != `["text message"]` {
+			t.Errorf("Bad response for %s: %v", query, actual)
+		}
+	}
+}
+
+func TestGetFeedbackReplies(t *testing.T) {
+	url := startServer(t)
+
+	id := createFeedback(url, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")
+	replyId := createFeedback(url, 1, receiver2, "reply", "POSITIVE", "2021-09-07 05:01:43")
+	createFeedback(url, 2, receiver1, "reply to reply", "POSITIVE", "2021-09-08 05:01:43")
+
+	jsonResponse := getBody("GET", url+"/feedback/"+id+"/replies?depth=1", nil, http.StatusOK)
+
+	re := regexp.MustCompile(`^{.*"id":1,.*"replies":\[{.*"id":2,.*"replies":\[\],.*}\],.*}$`)
+	if !re.MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+
+	getBody("DELETE", url+"/feedback/"+replyId, nil, http.StatusNoContent)
+
+	jsonResponse = getBody("GET", url+"/feedback/"+id+"/replies", nil, http.StatusOK)
+	if !regexp.MustCompile(`"replies":\[\]`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+
+	jsonResponse = getBody("GET", url+"/feedback/"+id+"/replies?with_trashed=1", nil, http.StatusOK)
+	if !regexp.MustCompile(`"message":"reply to reply"`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+}
+
+func TestGetFeedbackRepliesDepth(t *testing.T) {
+	url := startServer(t)
+
+	id := createFeedback(url, 0, receiver1, "level 0", "POSITIVE", "2021-09-06 05:01:43")
+	for level := 1; level <= 4; level++ {
+		createFeedback(url, level, receiver1, fmt.Sprintf("level %d", level), "POSITIVE", "2021-09-06 05:01:43")
+	}
+
+	// the default depth stops at the third level
+	jsonResponse := getBody("GET", url+"/feedback/"+id+"/replies", nil, http.StatusOK)
+	if !regexp.MustCompile(`"message":"level 3"`).MatchString(string(jsonResponse)) ||
+		regexp.MustCompile(`"message":"level 4"`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+
+	// a too deep request is capped instead of rejected
+	jsonResponse = getBody("GET", url+"/feedback/"+id+"/replies?depth=100", nil, http.StatusOK)
+	if !regexp.MustCompile(`"message":"level 4"`).MatchString(string(jsonResponse)) {
+		t.Errorf("Bad response: %v", string(jsonResponse))
+	}
+
+	jsonResponse = getBody("GET", url+"/feedback/"+id+"/replies?depth=0", nil, http.StatusBadRequest)
+
+	expected := `{"code":"validation_failed","details":{"depth":["The depth field must be a positive number!"]},"message":"The request is invalid!"}`
+	if expected != string(jsonResponse) {
+		t.Errorf("Bad response! Expected: %v, extual: %v", expected, string(jsonResponse))
 	}
-	for _, q := range strings.Split(string(file), ";") {
-		q := strings.TrimSpace(q)
-		if q == "" {
-			continue
+
+	getBody("GET", url+"/feedback/"+id+"/replies?depth=abc", nil, http.StatusBadRequest)
+	getBody("GET", url+"/feedback/100/replies", nil, http.StatusNotFound)
+}
+
+func TestGetFeedbacksByCursor(t *testing.T) {
+	url := startServer(t)
+
+	for day := 1; day <= 3; day++ {
+		createFeedback(url, 0, receiver1, fmt.Sprintf("message %d", day), "POSITIVE", fmt.Sprintf("2021-09-0%d 05:01:43", day))
+	}
+
+	type page struct {
+		Items []struct {
+			Message string `json:"message"`
+		} `json:"items"`
+		NextCursor string `json:"next_cursor"`
+	}
+	messages := make([]string, 0)
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		jsonResponse := getBody("GET", url+"/feedbacks?limit=2&with_total=0&cursor="+cursor, nil, http.StatusOK)
+		var page page
+		json.Unmarshal(jsonResponse, &page)
+		for _, item := range page.Items {
+			messages = append(messages, item.Message)
 		}
-		if _, err := tx.Exec(q); err != nil {
-			return err
+		if page.NextCursor == "" {
+			break
 		}
+		cursor = page.NextCursor
 	}
-	return tx.Commit()
+
+	expected := `["message 3","message 2","message 1"]`
+	actual, _ := json.Marshal(messages)
+	if expected != string(actual) {
+		t.Errorf("Bad pages! Expected: %v, extual: %v", expected, string(actual))
+	}
+
+	getBody("GET", url+"/feedbacks?cursor=garbage", nil, http.StatusBadRequest)
+	getBody("GET", url+"/feedbacks?sort=amount&cursor="+cursor, nil, http.StatusBadRequest)
+}
+
+func TestGetFeedbacksByCursorWithTies(t *testing.T) {
+	url := startServer(t)
+
+	// the same created_at leaves the id as the only tie-breaker
+	for i := 1; i <= 5; i++ {
+		createFeedback(url, 0, receiver1, fmt.Sprintf("message %d", i), "POSITIVE", "2021-09-06 05:01:43")
+	}
+
+	type page struct {
+		Items []struct {
+			ID int `json:"id"`
+		} `json:"items"`
+		Total      *int   `json:"total"`
+		NextCursor string `json:"next_cursor"`
+	}
+	ids := make([]int, 0)
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		jsonResponse := getBody("GET", url+"/feedbacks?limit=2&with_total=0&cursor="+cursor, nil, http.StatusOK)
+		var page page
+		json.Unmarshal(jsonResponse, &page)
+		if page.Total != nil {
+			t.Errorf("Unexpected total: %v", string(jsonResponse))
+		}
+		for _, item := range page.Items {
+			ids = append(ids, item.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	expected := `[5,4,3,2,1]`
+	actual, _ := json.Marshal(ids)
+	if expected != string(actual) {
+		t.Errorf("Bad pages! Expected: %v, extual: %v", expected, string(actual))
+	}
+}
+
+func startServer(t *testing.T) string {
+	server := httptest.NewServer(newRouter(memory.New()))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func createFeedback(url string, parentId int, receiverUuid, message, feedbackType, createdAt string) string {
+	var requestJson = []byte(fmt.Sprintf(createRequestTemplate, parentId, receiverUuid, message, feedbackType, createdAt))
+	jsonResponse := getBody("POST", url+"/feedbacks", bytes.NewBuffer(requestJson), http.StatusCreated)
+
+	match := regexp.MustCompile(`"id":(\d+),`).FindStringSubmatch(string(jsonResponse))
+	if match == nil {
+		panic("no id in the response " + string(jsonResponse))
+	}
+
+	return match[1]
+}
+
+// createFeedbackWith creates a feedback of the template overriding the given fields
+func createFeedbackWith(url string, fields map[string]interface{}) string {
+	var request map[string]interface{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(createRequestTemplate, 0, receiver1, "text message", "POSITIVE", "2021-09-06 05:01:43")), &request)
+	if err != nil {
+		panic(err)
+	}
+	for name, value := range fields {
+		request[name] = value
+	}
+
+	requestJson, err := json.Marshal(request)
+	if err != nil {
+		panic(err)
+	}
+	jsonResponse := getBody("POST", url+"/feedbacks", bytes.NewBuffer(requestJson), http.StatusCreated)
+
+	match := regexp.MustCompile(`"id":(\d+),`).FindStringSubmatch(string(jsonResponse))
+	if match == nil {
+		panic("no id in the response " + string(jsonResponse))
+	}
+
+	return match[1]
+}
+
+// getMessages lists the messages of the feedbacks page in order
+func getMessages(url string) string {
+	jsonResponse := getBody("GET", url, nil, http.StatusOK)
+
+	var page struct {
+		Items []struct {
+			Message string `json:"message"`
+		} `json:"items"`
+	}
+	err := json.Unmarshal(jsonResponse, &page)
+	if err != nil {
+		panic(err)
+	}
+
+	messages := make([]string, 0, len(page.Items))
+	for _, item := range page.Items {
+		messages = append(messages, item.Message)
+	}
+	jsonMessages, _ := json.Marshal(messages)
+
+	return string(jsonMessages)
 }
 
 func sendRequest(method, url string, body io.Reader) *http.Response {
@@ -158,7 +667,7 @@ func getBody(method, url string, body io.Reader, expectedStatusCode int) []byte
 	resp := sendRequest(method, url, body)
 
 	if resp.StatusCode != expectedStatusCode {
-		str, _ := fmt.Printf("invalid status code! %d (%s)", resp.StatusCode, http.StatusText(resp.StatusCode))
+		str := fmt.Sprintf("invalid status code! %d (%s)", resp.StatusCode, http.StatusText(resp.StatusCode))
 		panic(str)
 	}
 
@@ -6,6 +6,8 @@ require (
 	github.com/confluentinc/confluent-kafka-go v1.7.0 // indirect
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/gorilla/mux v1.8.0
-	github.com/joho/godotenv v1.4.0 // indirect
-	github.com/segmentio/kafka-go v0.4.17 // indirect
+	github.com/joho/godotenv v1.4.0
+	github.com/lib/pq v1.10.9
+	github.com/segmentio/kafka-go v0.4.17
+	modernc.org/sqlite v1.17.3
 )
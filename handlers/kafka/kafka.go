@@ -3,109 +3,172 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	repository "feedback-service-go/repositories"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	kafka "github.com/segmentio/kafka-go"
 )
 
-const (
-	topic         = "test"
-	brokerAddress = "localhost:9092"
-)
-
 type KafkaRequest struct {
 	Action  string          `json:"action"`
 	Version string          `json:"version"`
 	Payload json.RawMessage `json:"payload"`
 }
 
-func Consume(ctx context.Context, repo repository.Repository) {
+// ErrUnprocessable marks the messages no retry can handle, they go to the dead-letter topic
+var ErrUnprocessable = errors.New("unprocessable message")
+
+// Reader is the part of kafka.Reader the consumer needs
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Writer is the part of kafka.Writer the dead-letter topic needs
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// NewReader joins the KAFKA_GROUP_ID consumer group, the partitions of the
+// topic are shared between its members so a message is handled by one of them
+func NewReader() (*kafka.Reader, error) {
 	topicName := os.Getenv("KAFKA_TOPIC_NAME")
-	// topicGroupId := os.Getenv("KAFKA_GROUP_ID")
+	topicGroupId := os.Getenv("KAFKA_GROUP_ID")
 	topicBrokers := os.Getenv("KAFKA_BROKER_ADDRESS")
 
+	if topicGroupId == "" {
+		return nil, errors.New("KAFKA_GROUP_ID is required to commit the handled messages")
+	}
+
 	// create a new logger that outputs to stdout
 	// and has the `kafka reader` prefix
 	l := log.New(os.Stdout, "kafka reader: ", 0)
 	// initialize a new reader with the brokers and topic
 	// the groupID identifies the consumer and prevents
 	// it from receiving duplicate messages
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{topicBrokers},
-		Topic:   topicName,
-		// GroupID: topicGroupId,
-		// assign the logger to the reader
-		Logger: l,
-	})
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{topicBrokers},
+		Topic:       topicName,
+		GroupID:     topicGroupId,
+		Logger:      l,
+		MaxWait:     10 * time.Second,
+		MaxAttempts: 10,
+	}), nil
+}
+
+// NewDeadLetterWriter produces to KAFKA_DEAD_LETTER_TOPIC, every write waits
+// for all the replicas as the original message is committed right after it
+func NewDeadLetterWriter() (*kafka.Writer, error) {
+	deadLetterTopic := os.Getenv("KAFKA_DEAD_LETTER_TOPIC")
+	topicBrokers := os.Getenv("KAFKA_BROKER_ADDRESS")
+
+	if deadLetterTopic == "" {
+		return nil, errors.New("KAFKA_DEAD_LETTER_TOPIC is required to skip the unprocessable messages")
+	}
+
+	return &kafka.Writer{
+		Addr:         kafka.TCP(topicBrokers),
+		Topic:        deadLetterTopic,
+		RequiredAcks: kafka.RequireAll,
+	}, nil
+}
+
+// Consume fetches the messages and hands them to a pool of workers, the events
+// sharing a key are handled in the order they were fetched. A message is
+// committed once it and every message fetched before it from its partition are
+// handled, so a restart picks up from the first unhandled one. The retryable
+// failures are retried with retry, the unprocessable messages and the ones that
+// failed for good are moved to deadLetters and committed as well. It returns
+// nil once ctx is done and the error that stopped the consumer otherwise.
+func Consume(ctx context.Context, r Reader, deadLetters Writer, repo repository.Repository, workers int, retry Backoff) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tracked := newOffsets()
+	workerPool := newPool(ctx, workers, retry, deadLetters, repo)
+	committed := make(chan error, 1)
+	go func() {
+		committed <- commit(ctx, r, tracked, workerPool.results, cancel)
+	}()
+
+	err := fetch(ctx, r, deadLetters, tracked, workerPool)
+	cancel()
+	workerPool.close()
+	failure := <-committed
+
+	if failure != nil {
+		return failure
+	}
+	return err
+}
+
+// fetch queues the messages until ctx is done, a full worker queue holds it back
+func fetch(ctx context.Context, r Reader, deadLetters Writer, tracked *offsets, workerPool *pool) error {
 	for {
-		// the `ReadMessage` method blocks until we receive the next event
-		rawMsg, err := r.ReadMessage(ctx)
+		// the `FetchMessage` method blocks until we receive the next event
+		rawMsg, err := r.FetchMessage(ctx)
 		if err != nil {
-			panic("could not read message " + err.Error())
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("could not read message: %w", err)
 		}
-
-		var inputRequest KafkaRequest
-		err = json.Unmarshal(rawMsg.Value, &inputRequest)
-		if err != nil {
-			panic(err.Error())
+		log.Printf("fetched message at offset %d of partition %d\n", rawMsg.Offset, rawMsg.Partition)
+		tracked.track(rawMsg)
+
+		event, err := Actions.Decode(rawMsg.Value)
+		if err == nil {
+			err = workerPool.run(ctx, rawMsg, event)
+		} else if errors.Is(err, ErrUnprocessable) {
+			log.Printf("dead-lettering message at offset %d of partition %d: %v\n", rawMsg.Offset, rawMsg.Partition, err)
+			err = deadLetters.WriteMessages(ctx, DeadLetter(rawMsg, err))
+			if err == nil {
+				err = workerPool.skip(ctx, rawMsg)
+			}
 		}
-		fmt.Println("sucessfully got from Kafka:", string(rawMsg.Value))
-
-		switch inputRequest.Action {
-		case "create-action":
-			// TODO: check for inputRequest.Version
-			go CreateFeedback(inputRequest.Payload, repo)
-		case "update-action":
-			// TODO: check for inputRequest.Version
-			go UpdateFeedback(inputRequest.Payload, repo)
-		case "delete-offer-action":
-			// TODO: check for inputRequest.Version
-			go DeleteOffer(inputRequest.Payload, repo)
-		default:
-			fmt.Println("got unknown action:", inputRequest.Action)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("could not handle message at offset %d of partition %d: %w", rawMsg.Offset, rawMsg.Partition, err)
 		}
 	}
 }
 
-func CreateFeedback(payload json.RawMessage, repo repository.Repository) {
-	var request repository.CreateRequest
-	err := json.Unmarshal(payload, &request)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	repo.Create(&request)
+// DeadLetter copies the message for the dead-letter topic, the headers tell where
+// it came from and why it failed
+func DeadLetter(msg kafka.Message, reason error) kafka.Message {
+	headers := make([]kafka.Header, 0, len(msg.Headers)+4)
+	headers = append(headers, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: "dead-letter-reason", Value: []byte(reason.Error())},
+		kafka.Header{Key: "dead-letter-topic", Value: []byte(msg.Topic)},
+		kafka.Header{Key: "dead-letter-partition", Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: "dead-letter-offset", Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+
+	return kafka.Message{Key: msg.Key, Value: msg.Value, Headers: headers}
 }
 
-func UpdateFeedback(payload json.RawMessage, repo repository.Repository) {
-	var request repository.UpdateRequest
-	err := json.Unmarshal(payload, &request)
-	if err != nil {
-		panic(err.Error())
-	}
+func CreateFeedback(ctx context.Context, repo repository.Repository, payload Payload) error {
+	_, err := repo.Create(ctx, payload.(*repository.CreateRequest))
 
-	repo.Update(&request)
+	return err
 }
 
-func DeleteOffer(payload json.RawMessage, repo repository.Repository) {
-	var request repository.DeleteOfferRequest
-	err := json.Unmarshal(payload, &request)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	repo.DeleteOffer(&request)
+func UpdateFeedback(ctx context.Context, repo repository.Repository, payload Payload) error {
+	return repo.Update(ctx, payload.(*repository.UpdateRequest))
 }
 
-func ChangeTradeStatus(payload json.RawMessage, repo repository.Repository) {
-	var request repository.ChangeTradeStatusRequest
-	err := json.Unmarshal(payload, &request)
-	if err != nil {
-		panic(err.Error())
-	}
+func DeleteOffer(ctx context.Context, repo repository.Repository, payload Payload) error {
+	return repo.DeleteOffer(ctx, payload.(*repository.DeleteOfferRequest))
+}
 
-	repo.ChangeTradeStatus(&request)
+func ChangeTradeStatus(ctx context.Context, repo repository.Repository, payload Payload) error {
+	return repo.ChangeTradeStatus(ctx, payload.(*repository.ChangeTradeStatusRequest))
 }
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	repository "feedback-service-go/repositories"
+	memoryrepository "feedback-service-go/repositories/memory"
+)
+
+// fakeReader serves the messages in order, then blocks like an idle topic until ctx is done
+type fakeReader struct {
+	mu        sync.Mutex
+	messages  []kafka.Message
+	committed []int64
+	// handled is closed once the last message is committed
+	handled chan struct{}
+	last    int64
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if len(r.messages) == 0 {
+		r.mu.Unlock()
+		<-ctx.Done()
+		return kafka.Message{}, io.EOF
+	}
+
+	msg := r.messages[0]
+	r.messages = r.messages[1:]
+	r.mu.Unlock()
+	return msg, nil
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, msg := range msgs {
+		if len(r.committed) > 0 && msg.Offset <= r.committed[len(r.committed)-1] {
+			return fmt.Errorf("offset %d committed after %v", msg.Offset, r.committed)
+		}
+		r.committed = append(r.committed, msg.Offset)
+		if msg.Offset == r.last {
+			close(r.handled)
+		}
+	}
+	return nil
+}
+
+func (r *fakeReader) lastCommitted() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.committed) == 0 {
+		return -1
+	}
+	return r.committed[len(r.committed)-1]
+}
+
+type fakeWriter struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	err      error
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+// testBackoff retries quickly
+var testBackoff = Backoff{Attempts: 3, Initial: time.Millisecond, Max: 2 * time.Millisecond}
+
+func newReader(values ...string) *fakeReader {
+	r := &fakeReader{handled: make(chan struct{}), last: int64(len(values) - 1)}
+	for i, value := range values {
+		r.messages = append(r.messages, kafka.Message{Topic: "feedbacks", Partition: 2, Offset: int64(i), Value: []byte(value)})
+	}
+	return r
+}
+
+// consumeAll runs Consume until every message is committed
+func consumeAll(t *testing.T, r *fakeReader, deadLetters Writer, repo repository.Repository) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Consume(ctx, r, deadLetters, repo, 4, testBackoff)
+	}()
+
+	select {
+	case <-r.handled:
+	case err := <-done:
+		t.Fatalf("expected Consume to keep going, got %v", err)
+	}
+	cancel()
+
+	err := <-done
+	if err != nil {
+		t.Fatalf("expected Consume to stop quietly, got %v", err)
+	}
+}
+
+const createMessage = `{"action":"create-action","version":"v0.1","payload":{"sender_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b136","sender_name":"sender#1","sender_avatar":"sender#1 avatar","receiver_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b137","receiver_name":"receiver#1","receiver_avatar":"receiver#1 avatar","offer_hash":"ksO3jso7aDi","offer_authorized":true,"offer_owner_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b138","offer_type":"SELL","offer_payment_method":"PayPal","offer_payment_method_slug":"paypal_slug","offer_fiat_code":"RUB","offer_crypto_code":"BTC","trade_hash":"isO9AlIU8s2","trade_fiat_amount_requested_in_usd":"320.12","trade_status":"RELEASED","message":"message1","feedback_type":"POSITIVE"}}`
+
+func updateMessage(senderUuid string) string {
+	return fmt.Sprintf(`{"action":"update-action","version":"v0.1","payload":{"sender_uuid":"%s","receiver_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b137","offer_payment_method_slug":"paypal_slug","offer_fiat_code":"RUB","message":"message1 NEW"}}`, senderUuid)
+}
+
+func TestConsumeCommitsHandledMessages(t *testing.T) {
+	repo := memoryrepository.New()
+	r := newReader(
+		createMessage,
+		updateMessage("807a51d6-a81b-4b66-9596-5b17ea26b136"),
+	)
+
+	consumeAll(t, r, &fakeWriter{}, repo)
+
+	feedback, err := repo.FindByID(context.Background(), 1)
+	if err != nil || feedback.Message != "message1 NEW" {
+		t.Errorf("expected the feedback to be updated, got %+v, %v", feedback, err)
+	}
+}
+
+func TestConsumeDeadLettersPermanentFailures(t *testing.T) {
+	repo := memoryrepository.New()
+	r := newReader(
+		createMessage,
+		updateMessage("807a51d6-a81b-4b66-9596-5b17ea26b139"),
+		updateMessage("807a51d6-a81b-4b66-9596-5b17ea26b136"),
+	)
+	deadLetters := &fakeWriter{}
+
+	consumeAll(t, r, deadLetters, repo)
+
+	if len(deadLetters.messages) != 1 || string(deadLetters.messages[0].Value) != updateMessage("807a51d6-a81b-4b66-9596-5b17ea26b139") {
+		t.Fatalf("expected the update of a missing feedback to be dead-lettered, got %d messages", len(deadLetters.messages))
+	}
+}
+
+func TestConsumeStopsWhenDeadLetteringFails(t *testing.T) {
+	repo := memoryrepository.New()
+	r := newReader(
+		createMessage,
+		updateMessage("807a51d6-a81b-4b66-9596-5b17ea26b139"),
+		updateMessage("807a51d6-a81b-4b66-9596-5b17ea26b136"),
+	)
+	unavailable := errors.New("dead-letter topic unavailable")
+
+	err := Consume(context.Background(), r, &fakeWriter{err: unavailable}, repo, 4, testBackoff)
+	if !errors.Is(err, unavailable) {
+		t.Fatalf("expected the consumer to stop, got %v", err)
+	}
+	if r.lastCommitted() >= 1 {
+		t.Errorf("expected the failed message to stay uncommitted, got %v", r.committed)
+	}
+}
+
+func TestConsumeKeepsOrderOfKeys(t *testing.T) {
+	const trades = 50
+
+	repo := memoryrepository.New()
+	values := make([]string, 0, trades*3)
+	for i := 0; i < trades; i++ {
+		receiverUuid := fmt.Sprintf("807a51d6-a81b-4b66-9596-5b17ea26c%03d", i)
+		tradeHash := fmt.Sprintf("isO9AlIU%03d", i)
+		create := strings.NewReplacer(
+			"807a51d6-a81b-4b66-9596-5b17ea26b137", receiverUuid,
+			"isO9AlIU8s2", tradeHash,
+		).Replace(createMessage)
+
+		values = append(values,
+			create,
+			fmt.Sprintf(`{"action":"change-trade-status-action","version":"v0.1","payload":{"trade_hash":"%s","trade_status":"DISPUTED"}}`, tradeHash),
+			strings.Replace(updateMessage("807a51d6-a81b-4b66-9596-5b17ea26b136"), "807a51d6-a81b-4b66-9596-5b17ea26b137", receiverUuid, 1),
+		)
+	}
+	r := newReader(values...)
+
+	consumeAll(t, r, &fakeWriter{}, repo)
+
+	response, err := repo.Find(context.Background(), &repository.RequestFilter{Limit: trades})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if len(response.Items) != trades {
+		t.Fatalf("expected %d feedbacks, got %d", trades, len(response.Items))
+	}
+	for _, feedback := range response.Items {
+		if feedback.TradeStatus != "DISPUTED" || feedback.Message != "message1 NEW" {
+			t.Errorf("expected the events of %s to follow its create, got %s %q", feedback.TradeHash, feedback.TradeStatus, feedback.Message)
+		}
+	}
+}
+
+// slowRepository holds the create of one trade until it is released
+type slowRepository struct {
+	repository.Repository
+	tradeHash string
+	release   chan struct{}
+	created   chan string
+}
+
+func (r *slowRepository) Create(ctx context.Context, request *repository.CreateRequest) (int, error) {
+	if request.TradeHash == r.tradeHash {
+		<-r.release
+	}
+	id, err := r.Repository.Create(ctx, request)
+	r.created <- request.TradeHash
+	return id, err
+}
+
+func TestConsumeCommitsContiguousOffsets(t *testing.T) {
+	repo := &slowRepository{
+		Repository: memoryrepository.New(),
+		tradeHash:  "isO9AlIU000",
+		release:    make(chan struct{}),
+		created:    make(chan string, 3),
+	}
+	values := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		values = append(values, strings.NewReplacer(
+			"807a51d6-a81b-4b66-9596-5b17ea26b137", fmt.Sprintf("807a51d6-a81b-4b66-9596-5b17ea26c%03d", i),
+			"ksO3jso7aDi", fmt.Sprintf("ksO3jso7%03d", i),
+			"isO9AlIU8s2", fmt.Sprintf("isO9AlIU%03d", i),
+		).Replace(createMessage))
+	}
+	r := newReader(values...)
+
+	go func() {
+		// the later messages are handled while the first one is held
+		<-repo.created
+		<-repo.created
+		close(repo.release)
+	}()
+
+	consumeAll(t, r, &fakeWriter{}, repo)
+
+	if fmt.Sprint(r.committed) != "[2]" {
+		t.Errorf("expected nothing to be committed before the first message, got %v", r.committed)
+	}
+}
+
+func TestConsumeDeadLetters(t *testing.T) {
+	repo := memoryrepository.New()
+	r := newReader(
+		`{"action":`,
+		`{"action":"create-action","version":"v0.1","payload":{"sender_uuid":"nope"}}`,
+		`{"action":"delete-offer-action","version":"v0.1","payload":[]}`,
+		`{"action":"unknown-action","version":"v0.1","payload":{}}`,
+		createMessage,
+	)
+	deadLetters := &fakeWriter{}
+
+	consumeAll(t, r, deadLetters, repo)
+
+	if len(deadLetters.messages) != 4 {
+		t.Fatalf("expected 4 dead letters, got %d", len(deadLetters.messages))
+	}
+
+	msg := deadLetters.messages[1]
+	if string(msg.Value) != `{"action":"create-action","version":"v0.1","payload":{"sender_uuid":"nope"}}` {
+		t.Errorf("expected the original bytes, got %s", msg.Value)
+	}
+	headers := make(map[string]string)
+	for _, header := range msg.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	if headers["dead-letter-topic"] != "feedbacks" || headers["dead-letter-partition"] != "2" || headers["dead-letter-offset"] != "1" {
+		t.Errorf("expected the origin in the headers, got %v", headers)
+	}
+	if !strings.Contains(headers["dead-letter-reason"], "invalid create-action v0.1 payload") {
+		t.Errorf("expected the reason in the headers, got %q", headers["dead-letter-reason"])
+	}
+
+	_, err := repo.FindByID(context.Background(), 1)
+	if err != nil {
+		t.Errorf("expected the valid message after the bad ones to be stored, got %v", err)
+	}
+}
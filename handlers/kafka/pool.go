@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	repository "feedback-service-go/repositories"
+)
+
+const (
+	// defaultWorkers bounds the concurrent repository calls unless KAFKA_WORKERS says otherwise
+	defaultWorkers = 4
+	// workerQueueSize is how many events per worker may wait before the fetching stops
+	workerQueueSize = 16
+)
+
+// Workers reads the size of the worker pool from KAFKA_WORKERS
+func Workers() (int, error) {
+	inputWorkers := os.Getenv("KAFKA_WORKERS")
+	if inputWorkers == "" {
+		return defaultWorkers, nil
+	}
+
+	workers, err := strconv.Atoi(inputWorkers)
+	if err != nil || workers < 1 {
+		return 0, fmt.Errorf("KAFKA_WORKERS must be a positive number, got %q", inputWorkers)
+	}
+
+	return workers, nil
+}
+
+type job struct {
+	msg   kafka.Message
+	event *Event
+	// after are the jobs of the same keys queued earlier
+	after []chan struct{}
+	done  chan struct{}
+}
+
+type result struct {
+	msg kafka.Message
+	err error
+}
+
+// pool hands the events to a fixed number of workers in the order they were
+// fetched. An event waits for the earlier ones sharing any of its keys, they
+// were taken from the queue before it so they are running or done already.
+type pool struct {
+	queue   chan *job
+	results chan result
+	// last is the latest queued job of every key, only the fetching goroutine uses it
+	last map[string]chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newPool(ctx context.Context, workers int, retry Backoff, deadLetters Writer, repo repository.Repository) *pool {
+	p := &pool{
+		queue:   make(chan *job, workers*workerQueueSize),
+		results: make(chan result, workers*workerQueueSize),
+		last:    make(map[string]chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+
+			for job := range p.queue {
+				for _, earlier := range job.after {
+					<-earlier
+				}
+				err := retry.Do(ctx, func() error {
+					return job.event.Handle(ctx, repo)
+				})
+				if err != nil && ctx.Err() == nil {
+					log.Printf("dead-lettering message at offset %d of partition %d: %v\n", job.msg.Offset, job.msg.Partition, err)
+					err = deadLetters.WriteMessages(ctx, DeadLetter(job.msg, err))
+				}
+				close(job.done)
+				p.results <- result{msg: job.msg, err: err}
+			}
+		}()
+	}
+
+	return p
+}
+
+// run queues the event behind the earlier ones of its keys, blocking while the queue is full
+func (p *pool) run(ctx context.Context, msg kafka.Message, event *Event) error {
+	if len(p.last) > cap(p.queue)*4 {
+		p.forgetDone()
+	}
+
+	next := &job{msg: msg, event: event, done: make(chan struct{})}
+	for _, key := range event.Keys() {
+		if earlier, ok := p.last[key]; ok {
+			next.after = append(next.after, earlier)
+		}
+		p.last[key] = next.done
+	}
+
+	select {
+	case p.queue <- next:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// forgetDone drops the keys without running jobs
+func (p *pool) forgetDone() {
+	for key, done := range p.last {
+		select {
+		case <-done:
+			delete(p.last, key)
+		default:
+		}
+	}
+}
+
+// skip reports a message that needs no worker as handled
+func (p *pool) skip(ctx context.Context, msg kafka.Message) error {
+	select {
+	case p.results <- result{msg: msg}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close waits for the queued events and closes the results after them
+func (p *pool) close() {
+	close(p.queue)
+	p.wg.Wait()
+	close(p.results)
+}
+
+type partition struct {
+	topic string
+	id    int
+}
+
+// offsets keeps the fetched messages of every partition in order, only the
+// handled ones in front of the first unhandled one may be committed
+type offsets struct {
+	mu      sync.Mutex
+	pending map[partition][]kafka.Message
+	done    map[partition]map[int64]bool
+}
+
+func newOffsets() *offsets {
+	return &offsets{
+		pending: make(map[partition][]kafka.Message),
+		done:    make(map[partition]map[int64]bool),
+	}
+}
+
+// track must see the messages of a partition in the order they were fetched
+func (o *offsets) track(msg kafka.Message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	key := partition{topic: msg.Topic, id: msg.Partition}
+	o.pending[key] = append(o.pending[key], msg)
+	if o.done[key] == nil {
+		o.done[key] = make(map[int64]bool)
+	}
+}
+
+// complete marks the message handled and returns the last message of its
+// partition that can be committed now, if any
+func (o *offsets) complete(msg kafka.Message) (kafka.Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	key := partition{topic: msg.Topic, id: msg.Partition}
+	o.done[key][msg.Offset] = true
+
+	var last kafka.Message
+	advanced := false
+	pending := o.pending[key]
+	for len(pending) > 0 && o.done[key][pending[0].Offset] {
+		last = pending[0]
+		advanced = true
+		delete(o.done[key], last.Offset)
+		pending = pending[1:]
+	}
+	o.pending[key] = pending
+
+	return last, advanced
+}
+
+// commit commits the handled messages as they come until the results are closed.
+// The first failure cancels the consumer, nothing after it is committed.
+func commit(ctx context.Context, r Reader, tracked *offsets, results <-chan result, cancel context.CancelFunc) error {
+	var failure error
+	for first := range results {
+		ready := make(map[partition]kafka.Message)
+		collect := func(res result) {
+			if failure != nil || ctx.Err() != nil {
+				return
+			}
+			if res.err != nil {
+				failure = fmt.Errorf("could not handle message at offset %d of partition %d: %w", res.msg.Offset, res.msg.Partition, res.err)
+				cancel()
+				return
+			}
+			if last, ok := tracked.complete(res.msg); ok {
+				ready[partition{topic: last.Topic, id: last.Partition}] = last
+			}
+		}
+
+		// one commit per partition for everything handled meanwhile
+		collect(first)
+	drain:
+		for {
+			select {
+			case res, ok := <-results:
+				if !ok {
+					break drain
+				}
+				collect(res)
+			default:
+				break drain
+			}
+		}
+
+		// after a shutdown the uncommitted messages are handled again on the next start
+		if failure != nil || ctx.Err() != nil || len(ready) == 0 {
+			continue
+		}
+		msgs := make([]kafka.Message, 0, len(ready))
+		for _, msg := range ready {
+			msgs = append(msgs, msg)
+		}
+		err := r.CommitMessages(ctx, msgs...)
+		if err != nil && ctx.Err() == nil {
+			failure = fmt.Errorf("could not commit message: %w", err)
+			cancel()
+		}
+	}
+
+	return failure
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	repository "feedback-service-go/repositories"
+)
+
+// Payload is the request an event carries
+type Payload interface {
+	Validate() url.Values
+}
+
+// Handler stores the valid payload of an event, the payload is the one made by
+// the NewPayload of its action
+type Handler func(ctx context.Context, repo repository.Repository, payload Payload) error
+
+// Action is one version of an event, each version decodes its own payload
+type Action struct {
+	Name       string
+	Version    string
+	NewPayload func() Payload
+	Handle     Handler
+	// Keys name the entities the payload touches, the events sharing an entity
+	// are handled in the order they were fetched
+	Keys func(payload Payload) []string
+}
+
+// Event is a decoded message ready to be handled
+type Event struct {
+	Action  *Action
+	Payload Payload
+}
+
+func (e *Event) Keys() []string {
+	return e.Action.Keys(e.Payload)
+}
+
+func (e *Event) Handle(ctx context.Context, repo repository.Repository) error {
+	return e.Action.Handle(ctx, repo, e.Payload)
+}
+
+type actionKey struct {
+	name    string
+	version string
+}
+
+// Registry dispatches the events by their action and version
+type Registry struct {
+	actions map[actionKey]*Action
+}
+
+func NewRegistry() *Registry {
+	return &Registry{actions: make(map[actionKey]*Action)}
+}
+
+// Actions is the registry Consume dispatches with, a new version of a payload
+// is registered next to the old one so both are served while producers migrate
+var Actions = NewRegistry()
+
+func init() {
+	Actions.Register(&Action{
+		Name:       "create-action",
+		Version:    "v0.1",
+		NewPayload: func() Payload { return &repository.CreateRequest{} },
+		Handle:     CreateFeedback,
+		Keys: func(payload Payload) []string {
+			request := payload.(*repository.CreateRequest)
+			return []string{"trade:" + request.TradeHash, "offer:" + request.OfferHash, "receiver:" + request.ReceiverUuid}
+		},
+	})
+	Actions.Register(&Action{
+		Name:       "update-action",
+		Version:    "v0.1",
+		NewPayload: func() Payload { return &repository.UpdateRequest{} },
+		Handle:     UpdateFeedback,
+		Keys: func(payload Payload) []string {
+			return []string{"receiver:" + payload.(*repository.UpdateRequest).ReceiverUuid}
+		},
+	})
+	Actions.Register(&Action{
+		Name:       "delete-offer-action",
+		Version:    "v0.1",
+		NewPayload: func() Payload { return &repository.DeleteOfferRequest{} },
+		Handle:     DeleteOffer,
+		Keys: func(payload Payload) []string {
+			return []string{"offer:" + payload.(*repository.DeleteOfferRequest).OfferHash}
+		},
+	})
+	Actions.Register(&Action{
+		Name:       "change-trade-status-action",
+		Version:    "v0.1",
+		NewPayload: func() Payload { return &repository.ChangeTradeStatusRequest{} },
+		Handle:     ChangeTradeStatus,
+		Keys: func(payload Payload) []string {
+			return []string{"trade:" + payload.(*repository.ChangeTradeStatusRequest).TradeHash}
+		},
+	})
+}
+
+// Register adds the action, registering the same version twice is a programming error
+func (r *Registry) Register(action *Action) {
+	key := actionKey{name: action.Name, version: action.Version}
+	if _, ok := r.actions[key]; ok {
+		panic(fmt.Sprintf("action %s %s is already registered", action.Name, action.Version))
+	}
+	if action.NewPayload == nil || action.Handle == nil || action.Keys == nil {
+		panic(fmt.Sprintf("action %s %s needs NewPayload, Handle and Keys", action.Name, action.Version))
+	}
+
+	r.actions[key] = action
+}
+
+// Handle decodes a raw message and passes its valid payload to the handler of
+// its action and version
+func (r *Registry) Handle(ctx context.Context, value []byte, repo repository.Repository) error {
+	event, err := r.Decode(value)
+	if err != nil {
+		return err
+	}
+
+	return event.Handle(ctx, repo)
+}
+
+// Decode finds the action of a raw message and validates its payload. Anything
+// that can't be handled is ErrUnprocessable.
+func (r *Registry) Decode(value []byte) (*Event, error) {
+	var inputRequest KafkaRequest
+	err := json.Unmarshal(value, &inputRequest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid envelope: %v", ErrUnprocessable, err)
+	}
+
+	action, ok := r.actions[actionKey{name: inputRequest.Action, version: inputRequest.Version}]
+	if !ok {
+		if !r.knows(inputRequest.Action) {
+			return nil, fmt.Errorf("%w: unknown action %q", ErrUnprocessable, inputRequest.Action)
+		}
+		return nil, fmt.Errorf("%w: unknown version %q of %s", ErrUnprocessable, inputRequest.Version, inputRequest.Action)
+	}
+
+	payload := action.NewPayload()
+	err = json.Unmarshal(inputRequest.Payload, payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s %s payload: %v", ErrUnprocessable, action.Name, action.Version, err)
+	}
+
+	errs := payload.Validate()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: invalid %s %s payload: %v", ErrUnprocessable, action.Name, action.Version, errs)
+	}
+
+	return &Event{Action: action, Payload: payload}, nil
+}
+
+func (r *Registry) knows(name string) bool {
+	for key := range r.actions {
+		if key.name == name {
+			return true
+		}
+	}
+
+	return false
+}
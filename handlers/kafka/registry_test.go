@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	repository "feedback-service-go/repositories"
+	memoryrepository "feedback-service-go/repositories/memory"
+)
+
+// renamedUpdate is a payload of a made up next version where the fiat code got renamed
+type renamedUpdate struct {
+	repository.UpdateRequest
+	OfferCurrencyCode string `json:"offer_currency_code"`
+}
+
+func (request *renamedUpdate) Validate() url.Values {
+	request.OfferFiatCode = request.OfferCurrencyCode
+	return request.UpdateRequest.Validate()
+}
+
+func TestRegistryServesVersionsSideBySide(t *testing.T) {
+	ctx := context.Background()
+	repo := memoryrepository.New()
+
+	registry := NewRegistry()
+	registry.Register(Actions.actions[actionKey{name: "create-action", version: "v0.1"}])
+	registry.Register(Actions.actions[actionKey{name: "update-action", version: "v0.1"}])
+	registry.Register(&Action{
+		Name:       "update-action",
+		Version:    "v0.2",
+		NewPayload: func() Payload { return &renamedUpdate{} },
+		Handle: func(ctx context.Context, repo repository.Repository, payload Payload) error {
+			return UpdateFeedback(ctx, repo, &payload.(*renamedUpdate).UpdateRequest)
+		},
+		Keys: func(payload Payload) []string {
+			return []string{"receiver:" + payload.(*renamedUpdate).ReceiverUuid}
+		},
+	})
+
+	err := registry.Handle(ctx, []byte(createMessage), repo)
+	if err != nil {
+		t.Fatalf("create-action v0.1 failed: %v", err)
+	}
+
+	err = registry.Handle(ctx, []byte(updateMessage("807a51d6-a81b-4b66-9596-5b17ea26b136")), repo)
+	if err != nil {
+		t.Fatalf("update-action v0.1 failed: %v", err)
+	}
+
+	update := `{"action":"update-action","version":"v0.2","payload":{"sender_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b136","receiver_uuid":"807a51d6-a81b-4b66-9596-5b17ea26b137","offer_payment_method_slug":"paypal_slug","offer_currency_code":"RUB","message":"message1 v0.2"}}`
+	err = registry.Handle(ctx, []byte(update), repo)
+	if err != nil {
+		t.Fatalf("update-action v0.2 failed: %v", err)
+	}
+
+	feedback, err := repo.FindByID(ctx, 1)
+	if err != nil || feedback.Message != "message1 v0.2" {
+		t.Errorf("expected the v0.2 update to be stored, got %+v, %v", feedback, err)
+	}
+}
+
+func TestRegistryRejectsUnknownEvents(t *testing.T) {
+	for value, reason := range map[string]string{
+		`{"action":"create-action","version":"v9.9","payload":{}}`:      `unknown version "v9.9" of create-action`,
+		`{"action":"create-action","payload":{}}`:                       `unknown version "" of create-action`,
+		`{"action":"refund-action","version":"v0.1","payload":{}}`:      `unknown action "refund-action"`,
+		`{"action":"delete-offer-action","version":"v0.1","payload":1}`: "invalid delete-offer-action v0.1 payload",
+	} {
+		err := Actions.Handle(context.Background(), []byte(value), memoryrepository.New())
+		if !errors.Is(err, ErrUnprocessable) || !strings.Contains(err.Error(), reason) {
+			t.Errorf("expected %s to be unprocessable with %q, got %v", value, reason, err)
+		}
+	}
+}
+
+func TestRegistryRefusesDuplicates(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected the second create-action v0.1 to panic")
+		}
+	}()
+
+	Actions.Register(&Action{Name: "create-action", Version: "v0.1"})
+}
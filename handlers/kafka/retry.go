@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+const (
+	mysqlDeadlock        = 1213
+	mysqlLockWaitTimeout = 1205
+	sqliteBusy           = 5
+	sqliteLocked         = 6
+)
+
+// Retryable tells the failures that may pass on their own, like deadlocks, lock
+// timeouts and lost connections, from the ones every retry would repeat
+func Retryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDeadlock || mysqlErr.Number == mysqlLockWaitTimeout
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// serialization_failure, deadlock_detected and the connection exceptions
+		return pqErr.Code == "40001" || pqErr.Code == "40P01" || pqErr.Code.Class() == "08"
+	}
+
+	// the errors of modernc.org/sqlite carry the extended result code
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == sqliteBusy || code == sqliteLocked
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Backoff retries the retryable failures. The delays double from Initial up to
+// Max and a random part of up to half of each is taken off, so the workers that
+// failed together don't retry together.
+type Backoff struct {
+	Attempts int
+	Initial  time.Duration
+	Max      time.Duration
+}
+
+// DefaultBackoff gives up after about 3 seconds of retries
+var DefaultBackoff = Backoff{Attempts: 5, Initial: 200 * time.Millisecond, Max: 5 * time.Second}
+
+var (
+	jitterMu sync.Mutex
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// NewBackoff reads the number of attempts from KAFKA_RETRY_ATTEMPTS
+func NewBackoff() (Backoff, error) {
+	backoff := DefaultBackoff
+
+	inputAttempts := os.Getenv("KAFKA_RETRY_ATTEMPTS")
+	if inputAttempts == "" {
+		return backoff, nil
+	}
+
+	attempts, err := strconv.Atoi(inputAttempts)
+	if err != nil || attempts < 1 {
+		return backoff, fmt.Errorf("KAFKA_RETRY_ATTEMPTS must be a positive number, got %q", inputAttempts)
+	}
+	backoff.Attempts = attempts
+
+	return backoff, nil
+}
+
+// Do calls fn until it succeeds, fails for good, runs out of attempts or ctx is done
+func (b Backoff) Do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !Retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= b.Attempts {
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+
+		delay := b.delay(attempt)
+		log.Printf("attempt %d failed, retrying in %s: %v\n", attempt, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+func (b Backoff) delay(attempt int) time.Duration {
+	delay := b.Initial
+	for i := 1; i < attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	if delay < 2 {
+		return delay
+	}
+
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	return delay - time.Duration(jitter.Int63n(int64(delay/2)))
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+
+	repository "feedback-service-go/repositories"
+	memoryrepository "feedback-service-go/repositories/memory"
+)
+
+// sqliteError mimics the errors of modernc.org/sqlite
+type sqliteError int
+
+func (e sqliteError) Error() string { return fmt.Sprintf("sqlite error %d", int(e)) }
+func (e sqliteError) Code() int     { return int(e) }
+
+func TestRetryable(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		retryable bool
+	}{
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, true},
+		{fmt.Errorf("create: %w", &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}), true},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, false},
+		{mysql.ErrInvalidConn, true},
+		{driver.ErrBadConn, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{sqliteError(5), true},
+		{sqliteError(517), true},
+		{sqliteError(19), false},
+		{sql.ErrNoRows, false},
+		{errors.New("unknown feedback type"), false},
+	} {
+		if Retryable(test.err) != test.retryable {
+			t.Errorf("expected Retryable(%v) to be %v", test.err, test.retryable)
+		}
+	}
+}
+
+func TestBackoffDelays(t *testing.T) {
+	backoff := Backoff{Attempts: 10, Initial: 100 * time.Millisecond, Max: time.Second}
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		delay := backoff.delay(attempt + 1)
+		if delay > max || delay <= max/2 {
+			t.Errorf("expected delay #%d within (%s, %s], got %s", attempt+1, max/2, max, delay)
+		}
+	}
+}
+
+// flakyRepository fails the creates with an error until it runs out of failures
+type flakyRepository struct {
+	repository.Repository
+	mu       sync.Mutex
+	failures int
+	err      error
+	calls    int
+}
+
+func (r *flakyRepository) Create(ctx context.Context, request *repository.CreateRequest) (int, error) {
+	r.mu.Lock()
+	r.calls++
+	if r.failures > 0 {
+		r.failures--
+		r.mu.Unlock()
+		return 0, r.err
+	}
+	r.mu.Unlock()
+
+	return r.Repository.Create(ctx, request)
+}
+
+func TestConsumeRetriesTransientFailures(t *testing.T) {
+	repo := &flakyRepository{
+		Repository: memoryrepository.New(),
+		failures:   testBackoff.Attempts - 1,
+		err:        &mysql.MySQLError{Number: 1213, Message: "Deadlock found"},
+	}
+	r := newReader(createMessage)
+	deadLetters := &fakeWriter{}
+
+	consumeAll(t, r, deadLetters, repo)
+
+	if len(deadLetters.messages) != 0 {
+		t.Errorf("expected the retries to succeed, got %d dead letters", len(deadLetters.messages))
+	}
+	if _, err := repo.FindByID(context.Background(), 1); err != nil {
+		t.Errorf("expected the feedback to be stored, got %v", err)
+	}
+}
+
+func TestConsumeDeadLettersExhaustedRetries(t *testing.T) {
+	for _, test := range []struct {
+		err      error
+		attempts int
+		reason   string
+	}{
+		{&mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, testBackoff.Attempts, "gave up after 3 attempts"},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, 1, "Duplicate entry"},
+	} {
+		repo := &flakyRepository{Repository: memoryrepository.New(), failures: 10, err: test.err}
+		r := newReader(createMessage)
+		deadLetters := &fakeWriter{}
+
+		consumeAll(t, r, deadLetters, repo)
+
+		if repo.calls != test.attempts {
+			t.Errorf("expected %d attempts for %v, got %d", test.attempts, test.err, repo.calls)
+		}
+		if len(deadLetters.messages) != 1 {
+			t.Fatalf("expected %v to be dead-lettered, got %d dead letters", test.err, len(deadLetters.messages))
+		}
+		for _, header := range deadLetters.messages[0].Headers {
+			if header.Key == "dead-letter-reason" && !strings.Contains(string(header.Value), test.reason) {
+				t.Errorf("expected the reason to contain %q, got %q", test.reason, header.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+const (
+	codeBadRequest       = "bad_request"
+	codeValidationFailed = "validation_failed"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeInternalError    = "internal_error"
+)
+
+// ErrorResponse is the body of every non-2xx response. Details holds
+// per-field messages in the same shape the Validate methods return.
+type ErrorResponse struct {
+	Code    string     `json:"code"`
+	Message string     `json:"message"`
+	Details url.Values `json:"details,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	if payload == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string, details url.Values) {
+	writeJSON(w, status, &ErrorResponse{
+		Code:    code,
+		Message: message,
+		Details: details,
+	})
+}
+
+func writeValidationError(w http.ResponseWriter, details url.Values) {
+	writeError(w, http.StatusBadRequest, codeValidationFailed, "The request is invalid!", details)
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, codeNotFound, "The resource is not found!", nil)
+}
+
+// writeInternalError logs the cause and hides it from the client
+func writeInternalError(w http.ResponseWriter, err error) {
+	log.Println(err.Error())
+	writeError(w, http.StatusInternalServerError, codeInternalError, "Internal server error!", nil)
+}
+
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeNotFound(w)
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "The method is not allowed!", nil)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestWriteJSONWithoutPayload(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeJSON(recorder, http.StatusNoContent, nil)
+
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("expected the status %d, got %d", http.StatusNoContent, recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "" {
+		t.Errorf("expected no Content-Type, got %q", contentType)
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("expected an empty body, got %s", recorder.Body.String())
+	}
+}
+
+func TestWriteValidationError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeValidationError(recorder, url.Values{"id": {"The id must be a positive number!"}})
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected the status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a JSON response, got %q", recorder.Header().Get("Content-Type"))
+	}
+	expected := `{"code":"validation_failed","message":"The request is invalid!","details":{"id":["The id must be a positive number!"]}}` + "\n"
+	if recorder.Body.String() != expected {
+		t.Errorf("expected %s, got %s", expected, recorder.Body.String())
+	}
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recoverer turns a panic in a handler into a 500 response instead of
+// dropping the connection
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// the server relies on this panic to abort the response silently
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			log.Printf("panic while serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			writeError(w, http.StatusInternalServerError, codeInternalError, "Internal server error!", nil)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecoverer(t *testing.T) {
+	handler := Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(errors.New("boom"))
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/feedback/1", nil))
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected the status %d, got %d", http.StatusInternalServerError, recorder.Code)
+	}
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a JSON response, got %q", recorder.Header().Get("Content-Type"))
+	}
+	expected := `{"code":"internal_error","message":"Internal server error!"}` + "\n"
+	if recorder.Body.String() != expected {
+		t.Errorf("expected %s, got %s", expected, recorder.Body.String())
+	}
+}
+
+func TestRecovererRepanicsOnAbort(t *testing.T) {
+	handler := Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("expected http.ErrAbortHandler to be re-raised, got %v", rec)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/feedback/1", nil))
+}
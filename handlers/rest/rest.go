@@ -1,20 +1,28 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	repository "feedback-service-go/repositories"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 const (
-	defaultLimit = 10
-	maxLimit     = 1000
+	defaultLimit       = 10
+	maxLimit           = 1000
+	defaultThreadDepth = 3
+	maxThreadDepth     = 10
+
+	dateLayout = "2006-01-02"
 )
 
 type restHandler struct {
@@ -30,53 +38,307 @@ func New(repo repository.Repository) *restHandler {
 func (h *restHandler) GetFeedback(w http.ResponseWriter, r *http.Request) {
 	log.Println("GetFeedback")
 
-	inputFeedbackID := mux.Vars(r)["id"]
-	feedbackID, err := strconv.Atoi(inputFeedbackID)
+	feedbackID, err := getID(r)
 	if err != nil {
-		panic("can't convert string to int")
+		writeError(w, http.StatusBadRequest, codeBadRequest, "The id must be a positive number!", nil)
+		return
 	}
 
-	feedback, err := h.GetById(feedbackID)
+	feedback, err := h.GetById(r.Context(), feedbackID)
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
-			w.WriteHeader(http.StatusNotFound)
-			return
+			writeNotFound(w)
+		default:
+			writeInternalError(w, err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, feedback)
+}
+
+func (h *restHandler) CreateFeedback(w http.ResponseWriter, r *http.Request) {
+	log.Println("CreateFeedback")
+
+	var request repository.CreateRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "The body must be a valid JSON object!", url.Values{"body": {err.Error()}})
+		return
+	}
+
+	errs := request.Validate()
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	feedbackID, err := h.repo.Create(r.Context(), &request)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	feedback, err := h.GetById(r.Context(), feedbackID)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/feedback/%d", feedbackID))
+	writeJSON(w, http.StatusCreated, feedback)
+}
+
+func (h *restHandler) UpdateFeedback(w http.ResponseWriter, r *http.Request) {
+	log.Println("UpdateFeedback")
+
+	feedbackID, err := getID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "The id must be a positive number!", nil)
+		return
+	}
+
+	var request repository.PatchRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "The body must be a valid JSON object!", url.Values{"body": {err.Error()}})
+		return
+	}
+
+	errs := request.Validate()
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	err = h.repo.UpdateByID(r.Context(), feedbackID, &request)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			writeNotFound(w)
 		default:
-			panic(err.Error())
+			writeInternalError(w, err)
 		}
+		return
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+func (h *restHandler) DeleteFeedback(w http.ResponseWriter, r *http.Request) {
+	log.Println("DeleteFeedback")
+
+	feedbackID, err := getID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "The id must be a positive number!", nil)
+		return
+	}
+
+	err = h.repo.Delete(r.Context(), feedbackID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			writeNotFound(w)
+		default:
+			writeInternalError(w, err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+func (h *restHandler) RestoreFeedback(w http.ResponseWriter, r *http.Request) {
+	log.Println("RestoreFeedback")
 
+	feedbackID, err := getID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "The id must be a positive number!", nil)
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(feedback)
+	err = h.repo.Restore(r.Context(), feedbackID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			writeNotFound(w)
+		default:
+			writeInternalError(w, err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
 }
 
 func (h *restHandler) GetFeedbacksByFilter(w http.ResponseWriter, r *http.Request) {
 	log.Println("GetFeedbacksByFilter")
 
-	filter := getFilter(r.URL.Query())
+	filter, errs := getFilter(r.URL.Query())
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
 
-	response, err := h.repo.Find(filter)
+	response, err := h.repo.Find(r.Context(), filter)
 	if err != nil {
-		panic(err.Error())
+		writeInternalError(w, err)
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, http.StatusOK, response)
 }
 
-func (h *restHandler) GetById(id int) (*repository.Feedback, error) {
-	return h.repo.FindByID(id)
+func (h *restHandler) GetFeedbackReplies(w http.ResponseWriter, r *http.Request) {
+	log.Println("GetFeedbackReplies")
+
+	feedbackID, err := getID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "The id must be a positive number!", nil)
+		return
+	}
+
+	query := r.URL.Query()
+
+	depth := defaultThreadDepth
+	inputDepth := query.Get("depth")
+	if inputDepth != "" {
+		depth, err = strconv.Atoi(inputDepth)
+		if err != nil || depth < 1 {
+			writeValidationError(w, url.Values{"depth": {"The depth field must be a positive number!"}})
+			return
+		}
+	}
+	depth = min(depth, maxThreadDepth)
+
+	thread, err := h.repo.FindThread(r.Context(), feedbackID, depth, query.Get("with_trashed") == "1")
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			writeNotFound(w)
+		default:
+			writeInternalError(w, err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, thread)
+}
+
+func (h *restHandler) GetUserStats(w http.ResponseWriter, r *http.Request) {
+	log.Println("GetUserStats")
+
+	userUuid := mux.Vars(r)["uuid"]
+	if !repository.IsUuid(userUuid) {
+		writeValidationError(w, url.Values{"uuid": {"The uuid must be a UUID!"}})
+		return
+	}
+
+	stats, err := h.repo.GetStats(r.Context(), userUuid)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (h *restHandler) GetById(ctx context.Context, id int) (*repository.Feedback, error) {
+	return h.repo.FindByID(ctx, id)
 }
 
-func getFilter(query url.Values) *repository.RequestFilter {
+func getID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, err
+	}
+	if id < 1 {
+		return 0, fmt.Errorf("invalid id %d", id)
+	}
+
+	return id, nil
+}
+
+func getFilter(query url.Values) (*repository.RequestFilter, url.Values) {
 	filter := repository.RequestFilter{}
+	errs := url.Values{}
+
+	inputParentId := query.Get("parent_id")
+	if inputParentId != "" {
+		intVal, err := strconv.Atoi(inputParentId)
+		if err != nil || intVal < 1 {
+			errs.Add("parent_id", "The parent_id field must be a positive number!")
+		}
+		filter.ParentId = intVal
+	}
+
+	for name, value := range map[string]*string{
+		"sender_uuid":   &filter.SenderUuid,
+		"receiver_uuid": &filter.ReceiverUuid,
+	} {
+		*value = query.Get(name)
+		if *value != "" && !repository.IsUuid(*value) {
+			errs.Add(name, fmt.Sprintf("The %s field must be a UUID!", name))
+		}
+	}
+
+	for name, value := range map[string]*string{
+		"offer_hash": &filter.OfferHash,
+		"trade_hash": &filter.TradeHash,
+	} {
+		*value = query.Get(name)
+		if *value != "" && !repository.IsHash(*value) {
+			errs.Add(name, fmt.Sprintf("The %s field must be 11 alphanumeric chars!", name))
+		}
+	}
+
+	filter.FeedbackTypes = getList(query, "feedback_type")
+	filter.OfferPaymentMethodSlugs = getList(query, "offer_payment_method_slug")
+	filter.OfferFiatCodes = getList(query, "offer_fiat_code")
+	filter.OfferCryptoCodes = getList(query, "offer_crypto_code")
+	filter.OfferTypes = getList(query, "offer_type")
+	filter.TradeStatuses = getList(query, "trade_status")
+
+	for name, value := range map[string]*string{
+		"created_from": &filter.CreatedFrom,
+		"created_to":   &filter.CreatedTo,
+	} {
+		input := query.Get(name)
+		if input == "" {
+			continue
+		}
+		date, err := parseDate(input)
+		if err != nil {
+			errs.Add(name, fmt.Sprintf("The %s field must be formatted as '2006-01-02' or '2006-01-02 15:04:05'!", name))
+			continue
+		}
+		*value = date.Format(repository.DateTimeLayout)
+	}
+
+	for name, value := range map[string]*string{
+		"amount_min": &filter.AmountMin,
+		"amount_max": &filter.AmountMax,
+	} {
+		input := query.Get(name)
+		if input == "" {
+			continue
+		}
+		if !repository.IsAmount(input) {
+			errs.Add(name, fmt.Sprintf("The %s field must be a decimal with up to 8 integer and 2 fractional digits!", name))
+			continue
+		}
+		*value = input
+	}
+	if filter.AmountMin != "" && filter.AmountMax != "" {
+		// both passed IsAmount, so they parse
+		amountMin, _ := strconv.ParseFloat(filter.AmountMin, 64)
+		amountMax, _ := strconv.ParseFloat(filter.AmountMax, 64)
+		if amountMin > amountMax {
+			errs.Add("amount_min", "The amount_min field must not be greater than the amount_max one!")
+		}
+	}
 
-	filter.SenderUuid = query.Get("sender_uuid")
-	filter.ReceiverUuid = query.Get("receiver_uuid")
-	filter.OfferHash = query.Get("offer_hash")
-	filter.TradeHash = query.Get("trade_hash")
 	inputWithTrashed := query.Get("with_trashed")
 	if inputWithTrashed == "1" {
 		filter.WithTrashed = true
@@ -89,8 +351,8 @@ func getFilter(query url.Values) *repository.RequestFilter {
 		filter.Offset = 0
 	} else {
 		intVal, err := strconv.Atoi(inputOffset)
-		if err != nil {
-			panic(err.Error())
+		if err != nil || intVal < 0 {
+			errs.Add("offset", "The offset field must be a non-negative number!")
 		}
 		filter.Offset = intVal
 	}
@@ -100,15 +362,72 @@ func getFilter(query url.Values) *repository.RequestFilter {
 	if inputLimit != "" {
 		var err error
 		intVal, err = strconv.Atoi(inputLimit)
-		if err != nil {
-			panic(err.Error())
+		if err != nil || intVal < 0 {
+			errs.Add("limit", "The limit field must be a non-negative number!")
 		}
 	} else {
 		intVal = defaultLimit
 	}
 	filter.Limit = min(intVal, maxLimit)
 
-	return &filter
+	filter.Query = strings.TrimSpace(query.Get("q"))
+
+	filter.Sort = repository.DefaultSort
+	if filter.Query != "" {
+		filter.Sort = &repository.Sort{Field: repository.SortRelevance, Desc: true}
+	}
+	inputSort := query.Get("sort")
+	if inputSort != "" {
+		sort, err := repository.ParseSort(inputSort)
+		if err != nil {
+			errs.Add("sort", "The sort field must be one of 'created_at', 'updated_at', 'amount', 'feedback_type' or 'relevance' optionally prefixed by '-'!")
+		} else if sort.Field == repository.SortRelevance && filter.Query == "" {
+			errs.Add("sort", "The relevance order requires the q field!")
+		} else {
+			filter.Sort = sort
+		}
+	}
+
+	inputCursor := query.Get("cursor")
+	if inputCursor != "" {
+		cursor, err := repository.DecodeCursor(inputCursor)
+		if err != nil {
+			errs.Add("cursor", "The cursor field is malformed!")
+		} else if filter.Sort.Field == repository.SortRelevance {
+			errs.Add("cursor", "The relevance order supports only the offset pagination!")
+		} else if cursor.Sort != filter.Sort.String() {
+			errs.Add("cursor", "The cursor was issued for another sort order!")
+		}
+		filter.Cursor = cursor
+	}
+
+	filter.SkipTotal = query.Get("with_total") == "0"
+
+	return &filter, errs
+}
+
+// getList collects both repeated and comma separated values of the parameter
+func getList(query url.Values, name string) []string {
+	var list []string
+	for _, value := range query[name] {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+func parseDate(input string) (time.Time, error) {
+	date, err := time.Parse(repository.DateTimeLayout, input)
+	if err == nil {
+		return date, nil
+	}
+
+	return time.Parse(dateLayout, input)
 }
 
 func min(x, y int) int {
@@ -0,0 +1,606 @@
+package memoryrepository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	repository "feedback-service-go/repositories"
+)
+
+type stats struct {
+	positive int
+	negative int
+	initial  int
+}
+
+// memoryRepository keeps everything in maps guarded by a single lock. It
+// follows mysqlRepository: soft deletes, feedback_stats bookkeeping and
+// sql.ErrNoRows for missing rows.
+type memoryRepository struct {
+	mu        sync.RWMutex
+	lastID    int
+	feedbacks map[int]*repository.Feedback
+	stats     map[string]*stats
+}
+
+func New() repository.Repository {
+	return &memoryRepository{
+		feedbacks: make(map[int]*repository.Feedback),
+		stats:     make(map[string]*stats),
+	}
+}
+
+// GetDB returns nil as there is no database behind the repository
+func (r *memoryRepository) GetDB() *sql.DB {
+	return nil
+}
+
+func (r *memoryRepository) Close() {
+}
+
+func (r *memoryRepository) FindByID(ctx context.Context, id int) (*repository.Feedback, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	feedback, ok := r.feedbacks[id]
+	if !ok || feedback.DeletedAt.Valid {
+		return nil, sql.ErrNoRows
+	}
+
+	copied := *feedback
+	return &copied, nil
+}
+
+func (r *memoryRepository) Find(ctx context.Context, filter *repository.RequestFilter) (*repository.FeedbackResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sortOrder := filter.Sort
+	if sortOrder == nil {
+		sortOrder = repository.DefaultSort
+	}
+	if sortOrder.Field == repository.SortRelevance && (filter.Query == "" || filter.Cursor != nil) {
+		return nil, fmt.Errorf("relevance order requires a search query and offset pagination")
+	}
+
+	r.mu.RLock()
+	matched := make([]*repository.Feedback, 0)
+	for _, feedback := range r.feedbacks {
+		if matches(feedback, filter) {
+			copied := *feedback
+			matched = append(matched, &copied)
+		}
+	}
+	r.mu.RUnlock()
+
+	less := func(a, b *repository.Feedback) bool {
+		cmp := compare(sortOrder, a, b, filter.Query)
+		if cmp == 0 {
+			cmp = a.ID - b.ID
+		}
+		if sortOrder.Desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return less(matched[i], matched[j])
+	})
+
+	response := repository.FeedbackResponse{
+		Offser: filter.Offset,
+		Limit:  filter.Limit,
+	}
+
+	if !filter.SkipTotal {
+		total := len(matched)
+		response.Total = &total
+	}
+
+	start := filter.Offset
+	if filter.Cursor != nil {
+		last := &repository.Feedback{ID: filter.Cursor.ID}
+		setSortValue(sortOrder, last, filter.Cursor.Value)
+		start = sort.Search(len(matched), func(i int) bool {
+			return less(last, matched[i])
+		})
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > len(matched) {
+		start = len(matched)
+	}
+
+	feedbacks := matched[start:]
+	if len(feedbacks) > filter.Limit {
+		feedbacks = feedbacks[:filter.Limit]
+		if len(feedbacks) > 0 && sortOrder.Field != repository.SortRelevance {
+			response.NextCursor = repository.NewCursor(sortOrder, feedbacks[len(feedbacks)-1]).Encode()
+		}
+	}
+	response.Items = feedbacks
+
+	return &response, nil
+}
+
+func (r *memoryRepository) FindThread(ctx context.Context, id int, depth int, withTrashed bool) (*repository.FeedbackThread, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	feedback, ok := r.feedbacks[id]
+	if !ok || (feedback.DeletedAt.Valid && !withTrashed) {
+		return nil, sql.ErrNoRows
+	}
+
+	children := make(map[int][]*repository.Feedback)
+	for _, reply := range r.feedbacks {
+		if reply.ParentId.Valid && (withTrashed || !reply.DeletedAt.Valid) {
+			parentId := int(reply.ParentId.Int64)
+			children[parentId] = append(children[parentId], reply)
+		}
+	}
+	for _, replies := range children {
+		sort.Slice(replies, func(i, j int) bool {
+			if replies[i].CreatedAt != replies[j].CreatedAt {
+				return replies[i].CreatedAt < replies[j].CreatedAt
+			}
+			return replies[i].ID < replies[j].ID
+		})
+	}
+
+	return buildThread(feedback, children, depth), nil
+}
+
+func buildThread(feedback *repository.Feedback, children map[int][]*repository.Feedback, depth int) *repository.FeedbackThread {
+	copied := *feedback
+	thread := &repository.FeedbackThread{Feedback: &copied, Replies: []*repository.FeedbackThread{}}
+	if depth < 1 {
+		return thread
+	}
+
+	for _, reply := range children[feedback.ID] {
+		thread.Replies = append(thread.Replies, buildThread(reply, children, depth-1))
+	}
+
+	return thread
+}
+
+func (r *memoryRepository) Create(ctx context.Context, request *repository.CreateRequest) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if !repository.IsOneOf(request.FeedbackType, repository.FeedbackTypes) {
+		return 0, fmt.Errorf("unknown feedback type %q", request.FeedbackType)
+	}
+
+	currentTime := now()
+	createdAt := currentTime
+	if request.CreatedAt != "" {
+		date, err := time.Parse(repository.DateTimeLayout, request.CreatedAt)
+		if err != nil {
+			return 0, err
+		}
+		createdAt = date.Format(repository.DateTimeLayout)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	feedback := repository.Feedback{
+		SenderUuid:                    request.SenderUuid,
+		SenderName:                    request.SenderName,
+		SenderAvatar:                  request.SenderAvatar,
+		ReceiverUuid:                  request.ReceiverUuid,
+		ReceiverName:                  request.ReceiverName,
+		ReceiverAvatar:                request.ReceiverAvatar,
+		OfferHash:                     request.OfferHash,
+		OfferAthorized:                request.OfferAthorized,
+		OfferOwnerUuid:                request.OfferOwnerUuid,
+		OfferType:                     request.OfferType,
+		OfferPaymentMethod:            request.OfferPaymentMethod,
+		OfferPaymentMethodSlug:        request.OfferPaymentMethodSlug,
+		OfferFiatCode:                 request.OfferFiatCode,
+		OfferCryptoCode:               request.OfferCryptoCode,
+		TradeHash:                     request.TradeHash,
+		TradeFiatAmountRequestedInUsd: request.TradeFiatAmountRequestedInUsd,
+		TradeStatus:                   request.TradeStatus,
+		Message:                       request.Message,
+		FeedbackType:                  request.FeedbackType,
+		CreatedAt:                     createdAt,
+		UpdatedAt:                     currentTime,
+	}
+	if request.ParentId > 0 {
+		if _, ok := r.feedbacks[request.ParentId]; !ok {
+			return 0, fmt.Errorf("parent feedback %d doesn't exist", request.ParentId)
+		}
+		feedback.ParentId = repository.NullInt64{Int64: int64(request.ParentId), Valid: true}
+	}
+
+	r.lastID++
+	feedback.ID = r.lastID
+	r.feedbacks[feedback.ID] = &feedback
+	r.updateStats(feedback.ReceiverUuid, feedback.FeedbackType, 1)
+
+	return feedback.ID, nil
+}
+
+func (r *memoryRepository) Update(ctx context.Context, request *repository.UpdateRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var feedback *repository.Feedback
+	for _, candidate := range r.feedbacks {
+		if !candidate.DeletedAt.Valid &&
+			candidate.SenderUuid == request.SenderUuid &&
+			candidate.ReceiverUuid == request.ReceiverUuid &&
+			candidate.OfferPaymentMethodSlug == request.OfferPaymentMethodSlug &&
+			candidate.OfferFiatCode == request.OfferFiatCode &&
+			(feedback == nil || candidate.ID < feedback.ID) {
+			feedback = candidate
+		}
+	}
+	if feedback == nil {
+		return sql.ErrNoRows
+	}
+
+	return r.update(feedback, request.Message, request.FeedbackType)
+}
+
+func (r *memoryRepository) UpdateByID(ctx context.Context, id int, request *repository.PatchRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	feedback, ok := r.feedbacks[id]
+	if !ok || feedback.DeletedAt.Valid {
+		return sql.ErrNoRows
+	}
+
+	return r.update(feedback, request.Message, request.FeedbackType)
+}
+
+// update must be called with the write lock held
+func (r *memoryRepository) update(feedback *repository.Feedback, message string, feedbackType string) error {
+	if feedbackType != "" && feedbackType != feedback.FeedbackType {
+		if !repository.IsOneOf(feedbackType, repository.FeedbackTypes) {
+			return fmt.Errorf("unknown feedback type %q", feedbackType)
+		}
+
+		r.updateStats(feedback.ReceiverUuid, feedback.FeedbackType, -1)
+		r.updateStats(feedback.ReceiverUuid, feedbackType, 1)
+		feedback.FeedbackType = feedbackType
+	}
+
+	if message != "" {
+		feedback.Message = message
+	}
+	feedback.UpdatedAt = now()
+
+	return nil
+}
+
+func (r *memoryRepository) Delete(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	feedback, ok := r.feedbacks[id]
+	if !ok || feedback.DeletedAt.Valid {
+		return sql.ErrNoRows
+	}
+
+	feedback.DeletedAt = repository.NullString{String: now(), Valid: true}
+	r.updateStats(feedback.ReceiverUuid, feedback.FeedbackType, -1)
+
+	return nil
+}
+
+func (r *memoryRepository) Restore(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	feedback, ok := r.feedbacks[id]
+	if !ok || !feedback.DeletedAt.Valid {
+		return sql.ErrNoRows
+	}
+
+	feedback.DeletedAt = repository.NullString{}
+	r.updateStats(feedback.ReceiverUuid, feedback.FeedbackType, 1)
+
+	return nil
+}
+
+func (r *memoryRepository) DeleteOffer(ctx context.Context, request *repository.DeleteOfferRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	date, err := time.Parse(repository.DateTimeLayout, request.DeletedAt)
+	if err != nil {
+		return err
+	}
+	deletedAt := repository.NullString{String: date.Format(repository.DateTimeLayout), Valid: true}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	currentTime := now()
+	for _, feedback := range r.feedbacks {
+		if feedback.OfferHash == request.OfferHash {
+			feedback.OfferDeletedAt = deletedAt
+			feedback.UpdatedAt = currentTime
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryRepository) ChangeTradeStatus(ctx context.Context, request *repository.ChangeTradeStatusRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	currentTime := now()
+	for _, feedback := range r.feedbacks {
+		if feedback.TradeHash == request.TradeHash {
+			feedback.TradeStatus = request.TradeStatus
+			feedback.UpdatedAt = currentTime
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryRepository) GetStats(ctx context.Context, userUuid string) (*repository.Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	userStats, ok := r.stats[userUuid]
+	if !ok {
+		userStats = &stats{}
+	}
+
+	return repository.NewStats(userUuid, userStats.positive, userStats.negative, userStats.initial), nil
+}
+
+// RebuildStats recounts the counters in one go, the lock makes batches pointless here
+func (r *memoryRepository) RebuildStats(ctx context.Context, request *repository.RebuildStatsRequest) ([]*repository.StatsDiff, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := make(map[string]repository.StatsCounts)
+	for userUuid, userStats := range r.stats {
+		stored[userUuid] = repository.StatsCounts{Positive: userStats.positive, Negative: userStats.negative}
+	}
+
+	expected := make(map[string]repository.StatsCounts)
+	for _, feedback := range r.feedbacks {
+		counts := expected[feedback.ReceiverUuid]
+		if !feedback.DeletedAt.Valid {
+			switch feedback.FeedbackType {
+			case "POSITIVE":
+				counts.Positive++
+			case "NEGATIVE":
+				counts.Negative++
+			}
+		}
+		expected[feedback.ReceiverUuid] = counts
+	}
+
+	users := make([]string, 0, len(expected))
+	for userUuid := range expected {
+		users = append(users, userUuid)
+	}
+	for userUuid := range stored {
+		if _, ok := expected[userUuid]; !ok {
+			users = append(users, userUuid)
+		}
+	}
+	sort.Strings(users)
+
+	diffs := repository.NewStatsDiffs(users, stored, expected)
+	if request.DryRun {
+		return diffs, nil
+	}
+
+	for _, diff := range diffs {
+		userStats, ok := r.stats[diff.UserUuid]
+		if !ok {
+			userStats = &stats{}
+			r.stats[diff.UserUuid] = userStats
+		}
+		userStats.positive = diff.Expected.Positive
+		userStats.negative = diff.Expected.Negative
+	}
+
+	return diffs, nil
+}
+
+// updateStats must be called with the write lock held
+func (r *memoryRepository) updateStats(userUuid string, feedbackType string, delta int) {
+	userStats, ok := r.stats[userUuid]
+	if !ok {
+		userStats = &stats{}
+		r.stats[userUuid] = userStats
+	}
+
+	switch feedbackType {
+	case "POSITIVE":
+		userStats.positive += delta
+	case "NEGATIVE":
+		userStats.negative += delta
+	}
+}
+
+func matches(feedback *repository.Feedback, filter *repository.RequestFilter) bool {
+	if !filter.WithTrashed && feedback.DeletedAt.Valid {
+		return false
+	}
+	if filter.ParentId > 0 && (!feedback.ParentId.Valid || int(feedback.ParentId.Int64) != filter.ParentId) {
+		return false
+	}
+
+	for _, equal := range []struct {
+		expected string
+		actual   string
+	}{
+		{filter.SenderUuid, feedback.SenderUuid},
+		{filter.ReceiverUuid, feedback.ReceiverUuid},
+		{filter.OfferHash, feedback.OfferHash},
+		{filter.TradeHash, feedback.TradeHash},
+	} {
+		if equal.expected != "" && equal.expected != equal.actual {
+			return false
+		}
+	}
+
+	for _, in := range []struct {
+		values []string
+		actual string
+	}{
+		{filter.FeedbackTypes, feedback.FeedbackType},
+		{filter.OfferPaymentMethodSlugs, feedback.OfferPaymentMethodSlug},
+		{filter.OfferFiatCodes, feedback.OfferFiatCode},
+		{filter.OfferCryptoCodes, feedback.OfferCryptoCode},
+		{filter.OfferTypes, feedback.OfferType},
+		{filter.TradeStatuses, feedback.TradeStatus},
+	} {
+		if len(in.values) > 0 && !repository.IsOneOf(in.actual, in.values) {
+			return false
+		}
+	}
+
+	if filter.CreatedFrom != "" && feedback.CreatedAt < filter.CreatedFrom {
+		return false
+	}
+	if filter.CreatedTo != "" && feedback.CreatedAt >= filter.CreatedTo {
+		return false
+	}
+
+	// a missing amount is out of any bounds, like NULL is in SQL
+	amount := parseAmount(feedback.TradeFiatAmountRequestedInUsd)
+	missing := feedback.TradeFiatAmountRequestedInUsd == ""
+	if filter.AmountMin != "" && (missing || amount < parseAmount(filter.AmountMin)) {
+		return false
+	}
+	if filter.AmountMax != "" && (missing || amount > parseAmount(filter.AmountMax)) {
+		return false
+	}
+
+	if filter.Query != "" && !repository.MatchesQuery(feedback, filter.Query) {
+		return false
+	}
+
+	return true
+}
+
+// compare orders two feedbacks by the sort field only, ignoring the direction
+func compare(sortOrder *repository.Sort, a, b *repository.Feedback, query string) int {
+	switch sortOrder.Field {
+	case repository.SortAmount:
+		return compareAmounts(a.TradeFiatAmountRequestedInUsd, b.TradeFiatAmountRequestedInUsd)
+	case repository.SortRelevance:
+		return repository.Relevance(a, query) - repository.Relevance(b, query)
+	default:
+		return compareStrings(sortOrder.Value(a), sortOrder.Value(b))
+	}
+}
+
+// setSortValue puts a cursor value back into the field it was taken from
+func setSortValue(sortOrder *repository.Sort, feedback *repository.Feedback, value string) {
+	switch sortOrder.Field {
+	case repository.SortUpdatedAt:
+		feedback.UpdatedAt = value
+	case repository.SortAmount:
+		feedback.TradeFiatAmountRequestedInUsd = value
+	case repository.SortFeedbackType:
+		feedback.FeedbackType = value
+	default:
+		feedback.CreatedAt = value
+	}
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareAmounts sorts a missing amount as the lowest one, like NULL is in SQL
+func compareAmounts(a, b string) int {
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	case b == "":
+		return 1
+	default:
+		return compareFloats(parseAmount(a), parseAmount(b))
+	}
+}
+
+func parseAmount(value string) float64 {
+	amount, _ := strconv.ParseFloat(value, 64)
+	return amount
+}
+
+func now() string {
+	return time.Now().Format(repository.DateTimeLayout)
+}
@@ -0,0 +1,48 @@
+package memoryrepository
+
+import (
+	"context"
+	"testing"
+
+	repository "feedback-service-go/repositories"
+	"feedback-service-go/repositories/repositorytest"
+)
+
+func TestRepositorySuite(t *testing.T) {
+	repositorytest.RunSuite(t, func(t *testing.T) repository.Repository {
+		return New()
+	})
+}
+
+// TestRebuildStats corrupts the counters the suite can reach through SQL only
+func TestRebuildStats(t *testing.T) {
+	ctx := context.Background()
+	repo := New().(*memoryRepository)
+
+	request := repositorytest.NewCreateRequest("message")
+	_, err := repo.Create(ctx, request)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	repo.stats[request.ReceiverUuid].positive = 3
+	repo.stats["807a51d6-a81b-4b66-9596-5b17ea26f000"] = &stats{negative: 2}
+
+	diffs, err := repo.RebuildStats(ctx, &repository.RebuildStatsRequest{DryRun: true})
+	if err != nil {
+		t.Fatalf("RebuildStats failed: %v", err)
+	}
+	if len(diffs) != 2 || diffs[0].Stored.Positive != 3 || diffs[0].Expected.Positive != 1 || diffs[1].Expected.Negative != 0 {
+		t.Fatalf("unexpected diffs %+v", diffs)
+	}
+	if repo.stats[request.ReceiverUuid].positive != 3 {
+		t.Errorf("expected the dry run to keep the counters")
+	}
+
+	_, err = repo.RebuildStats(ctx, &repository.RebuildStatsRequest{})
+	if err != nil {
+		t.Fatalf("RebuildStats failed: %v", err)
+	}
+	if repo.stats[request.ReceiverUuid].positive != 1 || repo.stats["807a51d6-a81b-4b66-9596-5b17ea26f000"].negative != 0 {
+		t.Errorf("expected the counters to be fixed")
+	}
+}
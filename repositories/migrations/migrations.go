@@ -0,0 +1,302 @@
+// Package migrations keeps the versioned schema of every SQL backend. The
+// files of a backend live in its directory as <version>_<name>.up.sql and
+// <version>_<name>.down.sql, their statements end with a semicolon at the end
+// of a line. The applied versions are recorded in schema_migrations.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// ErrSchemaBehind is returned by Check when some migrations aren't applied yet
+var ErrSchemaBehind = errors.New("the database schema is behind, run the migrate command")
+
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+type Status struct {
+	*Migration
+	Applied   bool
+	AppliedAt string
+}
+
+type dialect struct {
+	createTable string
+	// appliedAt selects applied_at as "2006-01-02 15:04:05"
+	appliedAt string
+	// placeholder is the query parameter of the version
+	placeholder string
+}
+
+var dialects = map[string]dialect{
+	"mysql": {
+		createTable: "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)",
+		appliedAt:   "applied_at",
+		placeholder: "?",
+	},
+	"postgres": {
+		createTable: "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, applied_at TIMESTAMP(0) NOT NULL DEFAULT LOCALTIMESTAMP(0))",
+		appliedAt:   "to_char(applied_at, 'YYYY-MM-DD HH24:MI:SS')",
+		placeholder: "$1",
+	},
+	"sqlite": {
+		createTable: "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY, applied_at TEXT NOT NULL DEFAULT (datetime('now', 'localtime')))",
+		appliedAt:   "applied_at",
+		placeholder: "?",
+	},
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []*Migration
+}
+
+// New returns the migrator of the database for one of the "mysql", "postgres" or "sqlite" backends
+func New(db *sql.DB, backend string) (*Migrator, error) {
+	dialect, ok := dialects[backend]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for the %q backend", backend)
+	}
+
+	migrations, err := Load(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Load reads the migrations of the backend ordered by version
+func Load(backend string) ([]*Migration, error) {
+	names, err := fs.Glob(files, backend+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, path := range names {
+		file := strings.TrimPrefix(path, backend+"/")
+
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s is neither up nor down", path)
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(file, "."+direction+".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || version < 1 || len(parts) != 2 {
+			return nil, fmt.Errorf("migration %s must be named <version>_<name>.%s.sql", path, direction)
+		}
+
+		content, err := files.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = migration
+		}
+		if migration.Name != parts[1] {
+			return nil, fmt.Errorf("migration %s doesn't match the name %q of version %d", path, migration.Name, version)
+		}
+
+		if direction == "up" {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("migration %d_%s of %s needs both up and down files", migration.Version, migration.Name, backend)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Status lists every known migration and whether it is applied
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		list = append(list, &Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+	}
+
+	return list, nil
+}
+
+// Check returns ErrSchemaBehind when any migration is pending
+func (m *Migrator) Check(ctx context.Context) error {
+	list, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range list {
+		if !status.Applied {
+			return fmt.Errorf("%w: %d_%s is pending", ErrSchemaBehind, status.Version, status.Name)
+		}
+	}
+
+	return nil
+}
+
+// Up applies the pending migrations in order and returns them
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	list, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]*Migration, 0)
+	for _, status := range list {
+		if status.Applied {
+			continue
+		}
+
+		log.Printf("applying migration %d_%s", status.Version, status.Name)
+		err = m.run(ctx, status.up, "INSERT INTO schema_migrations (version) VALUES ("+m.dialect.placeholder+")", status.Version)
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s failed: %w", status.Version, status.Name, err)
+		}
+		done = append(done, status.Migration)
+	}
+
+	return done, nil
+}
+
+// Down reverts up to steps of the latest applied migrations and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	list, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]*Migration, 0)
+	for i := len(list) - 1; i >= 0 && len(done) < steps; i-- {
+		status := list[i]
+		if !status.Applied {
+			continue
+		}
+
+		log.Printf("reverting migration %d_%s", status.Version, status.Name)
+		err = m.run(ctx, status.down, "DELETE FROM schema_migrations WHERE version = "+m.dialect.placeholder, status.Version)
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s failed: %w", status.Version, status.Name, err)
+		}
+		done = append(done, status.Migration)
+	}
+
+	return done, nil
+}
+
+// run executes the script and records the version in one transaction. MySQL
+// commits every DDL statement implicitly, a failed script may stay half applied there.
+func (m *Migrator) run(ctx context.Context, script string, record string, version int) (err error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	for _, statement := range statements(script) {
+		_, err = tx.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, record, version)
+
+	return err
+}
+
+// applied maps the applied versions to the time they were applied at
+func (m *Migrator) applied(ctx context.Context) (map[int]string, error) {
+	_, err := m.db.ExecContext(ctx, m.dialect.createTable)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := m.db.QueryContext(ctx, "SELECT version, "+m.dialect.appliedAt+" FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	applied := make(map[int]string)
+	for results.Next() {
+		var version int
+		var appliedAt string
+		err = results.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, results.Err()
+}
+
+// statements splits a script by the semicolons ending its lines, the drivers
+// run one statement per call unless told otherwise
+func statements(script string) []string {
+	list := make([]string, 0)
+	var statement strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		statement.WriteString(line)
+		statement.WriteString("\n")
+
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			list = append(list, strings.TrimSuffix(strings.TrimSpace(statement.String()), ";"))
+			statement.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(statement.String()); rest != "" {
+		list = append(list, rest)
+	}
+
+	return list
+}
@@ -0,0 +1,135 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+var ctx = context.Background()
+
+func TestLoad(t *testing.T) {
+	for backend := range dialects {
+		migrations, err := Load(backend)
+		if err != nil {
+			t.Fatalf("Load(%s) failed: %v", backend, err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("Load(%s) found no migrations", backend)
+		}
+
+		for i, migration := range migrations {
+			if migration.Version != i+1 {
+				t.Errorf("expected %s migration #%d to have version %d, got %d_%s", backend, i, i+1, migration.Version, migration.Name)
+			}
+		}
+	}
+}
+
+func TestBackendsShareVersions(t *testing.T) {
+	expected, err := Load("mysql")
+	if err != nil {
+		t.Fatalf("Load(mysql) failed: %v", err)
+	}
+
+	for backend := range dialects {
+		migrations, _ := Load(backend)
+		if fmt.Sprint(names(migrations)) != fmt.Sprint(names(expected)) {
+			t.Errorf("expected %s to have the migrations %v, got %v", backend, names(expected), names(migrations))
+		}
+	}
+}
+
+func TestUpDownStatus(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "feedbacks.db"))
+	if err != nil {
+		t.Fatalf("can't open the database: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := New(db, "sqlite")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	total := len(migrator.migrations)
+
+	err = migrator.Check(ctx)
+	if !errors.Is(err, ErrSchemaBehind) {
+		t.Errorf("expected ErrSchemaBehind on an empty database, got %v", err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if len(applied) != total {
+		t.Errorf("expected %d migrations to be applied, got %d", total, len(applied))
+	}
+	err = migrator.Check(ctx)
+	if err != nil {
+		t.Errorf("expected the schema to be up to date, got %v", err)
+	}
+	_, err = db.Exec("SELECT COUNT(*) FROM feedbacks")
+	if err != nil {
+		t.Errorf("expected the feedbacks table, got %v", err)
+	}
+
+	applied, err = migrator.Up(ctx)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("expected nothing to apply twice, got %v, %v", applied, err)
+	}
+
+	list, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	for _, status := range list {
+		if !status.Applied || status.AppliedAt == "" {
+			t.Errorf("expected %d_%s to be applied, got %+v", status.Version, status.Name, status)
+		}
+	}
+
+	reverted, err := migrator.Down(ctx, total+1)
+	if err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if len(reverted) != total || reverted[0].Version != total {
+		t.Errorf("expected %d migrations to be reverted from the latest, got %v", total, names(reverted))
+	}
+	_, err = db.Exec("SELECT COUNT(*) FROM feedbacks")
+	if err == nil {
+		t.Errorf("expected the feedbacks table to be dropped")
+	}
+	err = migrator.Check(ctx)
+	if !errors.Is(err, ErrSchemaBehind) {
+		t.Errorf("expected ErrSchemaBehind after reverting everything, got %v", err)
+	}
+}
+
+func TestUnknownBackend(t *testing.T) {
+	_, err := New(nil, "oracle")
+	if err == nil {
+		t.Errorf("expected no migrations for oracle")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	list := statements("CREATE TABLE a(\n    b TEXT DEFAULT ';'\n);\n\nCREATE INDEX c ON a (b);\nDROP TABLE d")
+	expected := []string{"CREATE TABLE a(\n    b TEXT DEFAULT ';'\n)", "CREATE INDEX c ON a (b)", "DROP TABLE d"}
+	if fmt.Sprintf("%q", list) != fmt.Sprintf("%q", expected) {
+		t.Errorf("expected %q, got %q", expected, list)
+	}
+}
+
+func names(migrations []*Migration) []string {
+	list := make([]string, 0, len(migrations))
+	for _, migration := range migrations {
+		list = append(list, fmt.Sprintf("%d_%s", migration.Version, migration.Name))
+	}
+	return list
+}
@@ -0,0 +1 @@
+ALTER TABLE feedbacks DROP INDEX message_ft_idx;
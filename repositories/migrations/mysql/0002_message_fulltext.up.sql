@@ -0,0 +1 @@
+ALTER TABLE feedbacks ADD FULLTEXT INDEX message_ft_idx (message);
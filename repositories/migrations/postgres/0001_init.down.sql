@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS feedback_stats;
+DROP TABLE IF EXISTS feedbacks;
@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS feedbacks(
+    id INT GENERATED BY DEFAULT AS IDENTITY,
+    parent_id INT DEFAULT NULL,
+    sender_uuid UUID NOT NULL,
+    sender_name VARCHAR(64) NOT NULL,
+    sender_avatar VARCHAR(128) NOT NULL,
+    receiver_uuid UUID NOT NULL,
+    receiver_name VARCHAR(16) NOT NULL,
+    receiver_avatar VARCHAR(128) NOT NULL,
+    offer_hash CHAR(11) NOT NULL,
+    offer_authorized BOOLEAN NOT NULL,
+    offer_owner_uuid UUID NOT NULL,
+    offer_type VARCHAR(4) NOT NULL CHECK (offer_type IN ('BUY', 'SELL')),
+    offer_payment_method VARCHAR(64) NOT NULL,
+    offer_payment_method_slug VARCHAR(64) NOT NULL,
+    offer_fiat_code CHAR(3) NOT NULL CHECK (offer_fiat_code IN ('USD', 'EUR', 'RUB', 'PLN', 'CNY', 'VES', 'NGN')),
+    offer_crypto_code VARCHAR(12) NOT NULL,
+    offer_deleted_at TIMESTAMP(0) NULL DEFAULT NULL,
+    trade_hash CHAR(11) NOT NULL,
+    trade_fiat_amount_requested_in_usd NUMERIC(10, 2) NOT NULL,
+    trade_status VARCHAR(9) NOT NULL CHECK (trade_status IN ('RELEASED', 'CANCELLED', 'DISPUTED')),
+    message TEXT NOT NULL,
+    feedback_type VARCHAR(8) NOT NULL CHECK (feedback_type IN ('POSITIVE', 'NEGATIVE')),
+    created_at TIMESTAMP(0) NOT NULL DEFAULT LOCALTIMESTAMP(0),
+    updated_at TIMESTAMP(0) NOT NULL DEFAULT LOCALTIMESTAMP(0),
+    deleted_at TIMESTAMP(0) NULL DEFAULT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (parent_id) REFERENCES feedbacks (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS feedbacks_parent_id_idx ON feedbacks (parent_id);
+CREATE INDEX IF NOT EXISTS feedbacks_offer_hash_idx ON feedbacks (offer_hash);
+CREATE INDEX IF NOT EXISTS feedbacks_trade_hash_idx ON feedbacks (trade_hash);
+CREATE INDEX IF NOT EXISTS feedbacks_sender_receiver_payment_method_fiat_code_idx ON feedbacks (sender_uuid, receiver_uuid, offer_payment_method_slug, offer_fiat_code);
+
+CREATE TABLE IF NOT EXISTS feedback_stats(
+    user_uuid UUID NOT NULL,
+    positive INT NOT NULL DEFAULT 0,
+    negative INT NOT NULL DEFAULT 0,
+    initial INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_uuid)
+);
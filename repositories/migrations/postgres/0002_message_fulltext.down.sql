@@ -0,0 +1 @@
+DROP INDEX IF EXISTS feedbacks_message_ft_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS feedbacks_message_ft_idx ON feedbacks USING GIN (to_tsvector('simple', message));
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS feedbacks(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    parent_id INTEGER DEFAULT NULL REFERENCES feedbacks (id) ON DELETE CASCADE,
+    sender_uuid TEXT NOT NULL,
+    sender_name TEXT NOT NULL,
+    sender_avatar TEXT NOT NULL,
+    receiver_uuid TEXT NOT NULL,
+    receiver_name TEXT NOT NULL,
+    receiver_avatar TEXT NOT NULL,
+    offer_hash TEXT NOT NULL,
+    offer_authorized INTEGER NOT NULL,
+    offer_owner_uuid TEXT NOT NULL,
+    offer_type TEXT NOT NULL CHECK (offer_type IN ('BUY', 'SELL')),
+    offer_payment_method TEXT NOT NULL,
+    offer_payment_method_slug TEXT NOT NULL,
+    offer_fiat_code TEXT NOT NULL CHECK (offer_fiat_code IN ('USD', 'EUR', 'RUB', 'PLN', 'CNY', 'VES', 'NGN')),
+    offer_crypto_code TEXT NOT NULL,
+    offer_deleted_at TEXT DEFAULT NULL,
+    trade_hash TEXT NOT NULL,
+    trade_fiat_amount_requested_in_usd NUMERIC NOT NULL,
+    trade_status TEXT NOT NULL CHECK (trade_status IN ('RELEASED', 'CANCELLED', 'DISPUTED')),
+    message TEXT NOT NULL,
+    feedback_type TEXT NOT NULL CHECK (feedback_type IN ('POSITIVE', 'NEGATIVE')),
+    created_at TEXT NOT NULL DEFAULT (datetime('now', 'localtime')),
+    updated_at TEXT NOT NULL DEFAULT (datetime('now', 'localtime')),
+    deleted_at TEXT DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS feedbacks_parent_id_idx ON feedbacks (parent_id);
+CREATE INDEX IF NOT EXISTS feedbacks_offer_hash_idx ON feedbacks (offer_hash);
+CREATE INDEX IF NOT EXISTS feedbacks_trade_hash_idx ON feedbacks (trade_hash);
+CREATE INDEX IF NOT EXISTS feedbacks_sender_receiver_payment_method_fiat_code_idx ON feedbacks (sender_uuid, receiver_uuid, offer_payment_method_slug, offer_fiat_code);
+
+CREATE TABLE IF NOT EXISTS feedback_stats(
+    user_uuid TEXT NOT NULL PRIMARY KEY,
+    positive INTEGER NOT NULL DEFAULT 0,
+    negative INTEGER NOT NULL DEFAULT 0,
+    initial INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1 @@
+-- nothing to index, the search scans the messages with instr
@@ -1,48 +1,56 @@
 package mysqlrepository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"strconv"
-
-	"github.com/joho/godotenv"
+	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 
 	repository "feedback-service-go/repositories"
 )
 
+const feedbackColumns string = "id, parent_id, BIN_TO_UUID(sender_uuid), sender_name, sender_avater, BIN_TO_UUID(receiver_uuid), receiver_name, receiver_avater, offer_hash, offer_authorized, BIN_TO_UUID(offer_owner_uuid), offer_type, offer_payment_method, offer_payment_method_slug, offer_fiat_code, offer_crypto_code, offer_deleted_at, trade_hash, trade_fiat_amount_requested_in_usd, trade_status, message, feedback_type, created_at, updated_at, deleted_at"
+
+// sortColumns whitelists the expressions Find may order by. The feedback
+// type is compared as a string because ENUM columns are sorted by index.
+var sortColumns = map[string]string{
+	repository.SortCreatedAt:    "created_at",
+	repository.SortUpdatedAt:    "updated_at",
+	repository.SortAmount:       "trade_fiat_amount_requested_in_usd",
+	repository.SortFeedbackType: "CAST(feedback_type AS CHAR)",
+	repository.SortRelevance:    "MATCH(message) AGAINST(? IN NATURAL LANGUAGE MODE)",
+}
+
 type mysqlRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	timeout time.Duration
 }
 
 func (r *mysqlRepository) GetDB() *sql.DB {
 	return r.db
 }
 
-func New() (repository.Repository, error) {
-	err := godotenv.Load(".env")
-	if err != nil {
-		panic(err.Error())
-	}
-
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
-
-	dsn := fmt.Sprintf(
+// DSN builds the go-sql-driver DSN of the database configured by the DB_* env vars
+func DSN() string {
+	return fmt.Sprintf(
 		"%s:%s@tcp(%s:%s)/%s",
-		dbUser,
-		dbPassword,
-		dbHost,
-		dbPort,
-		dbName,
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_PORT"),
+		os.Getenv("DB_NAME"),
 	)
+}
 
+// Open connects to the database by a go-sql-driver DSN. A zero queryTimeout
+// leaves the calls bounded by the caller's context only.
+func Open(dsn string, queryTimeout time.Duration) (repository.Repository, error) {
 	dbConnection, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return nil, err
@@ -56,136 +64,253 @@ func New() (repository.Repository, error) {
 	// dbConnection.SetMaxIdleConns(idleConn)
 	// dbConnection.SetMaxOpenConns(maxConn)
 
-	return &mysqlRepository{db: dbConnection}, nil
+	return &mysqlRepository{db: dbConnection, timeout: queryTimeout}, nil
+}
+
+func (r *mysqlRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
 }
 
 func (r *mysqlRepository) Close() {
 	r.db.Close()
 }
 
-func (r *mysqlRepository) FindByID(id int) (*repository.Feedback, error) {
-	const queryTemplate string = `SELECT id, parent_id, BIN_TO_UUID(sender_uuid), sender_name, sender_avater, BIN_TO_UUID(receiver_uuid), receiver_name, receiver_avater, offer_hash, offer_authorized, BIN_TO_UUID(offer_owner_uuid), offer_type, offer_payment_method, offer_payment_method_slug, offer_fiat_code, offer_crypto_code, offer_deleted_at, trade_hash, trade_fiat_amount_requested_in_usd, trade_status, message, feedback_type, created_at, updated_at, deleted_at FROM feedbacks WHERE id = ? AND deleted_at IS NULL`
+func (r *mysqlRepository) FindByID(ctx context.Context, id int) (*repository.Feedback, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	var feedback repository.Feedback
-	result := r.db.QueryRow(queryTemplate, id)
-	err := result.Scan(
-		&feedback.ID,
-		&feedback.ParentId,
-		&feedback.SenderUuid,
-		&feedback.SenderName,
-		&feedback.SenderAvatar,
-		&feedback.ReceiverUuid,
-		&feedback.ReceiverName,
-		&feedback.ReceiverAvatar,
-		&feedback.OfferHash,
-		&feedback.OfferAthorized,
-		&feedback.OfferOwnerUuid,
-		&feedback.OfferType,
-		&feedback.OfferPaymentMethod,
-		&feedback.OfferPaymentMethodSlug,
-		&feedback.OfferFiatCode,
-		&feedback.OfferCryptoCode,
-		&feedback.DeletedAt,
-		&feedback.TradeHash,
-		&feedback.TradeFiatAmountRequestedInUsd,
-		&feedback.TradeStatus,
-		&feedback.Message,
-		&feedback.FeedbackType,
-		&feedback.CreatedAt,
-		&feedback.UpdatedAt,
-		&feedback.DeletedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
+	const queryTemplate string = "SELECT " + feedbackColumns + " FROM feedbacks WHERE id = ? AND deleted_at IS NULL"
 
-	return &feedback, nil
+	return scanFeedback(r.db.QueryRowContext(ctx, queryTemplate, id))
 }
 
-func (r *mysqlRepository) Find(filter *repository.RequestFilter) (*repository.FeedbackResponse, error) {
+func (r *mysqlRepository) Find(ctx context.Context, filter *repository.RequestFilter) (*repository.FeedbackResponse, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
 	feedbacks := make([]*repository.Feedback, 0)
 
-	sql := "SELECT %s FROM feedbacks WHERE 1=1"
+	where := "1=1"
+	args := make([]interface{}, 0)
 	if !filter.WithTrashed {
-		sql += " AND deleted_at IS NULL"
+		where += " AND deleted_at IS NULL"
+	}
+	if filter.ParentId > 0 {
+		where += " AND parent_id = ?"
+		args = append(args, filter.ParentId)
 	}
 	if filter.SenderUuid != "" {
-		sql += fmt.Sprintf(" AND sender_uuid = UUID_TO_BIN('%s')", filter.SenderUuid)
+		where += " AND sender_uuid = UUID_TO_BIN(?)"
+		args = append(args, filter.SenderUuid)
 	}
 	if filter.ReceiverUuid != "" {
-		sql += fmt.Sprintf(" AND receiver_uuid = UUID_TO_BIN('%s')", filter.ReceiverUuid)
+		where += " AND receiver_uuid = UUID_TO_BIN(?)"
+		args = append(args, filter.ReceiverUuid)
 	}
 	if filter.OfferHash != "" {
-		sql += fmt.Sprintf(" AND offer_hash = UUID_TO_BIN('%s')", filter.OfferHash)
+		where += " AND offer_hash = ?"
+		args = append(args, filter.OfferHash)
 	}
 	if filter.TradeHash != "" {
-		sql += fmt.Sprintf(" AND trade_hash = UUID_TO_BIN('%s')", filter.TradeHash)
+		where += " AND trade_hash = ?"
+		args = append(args, filter.TradeHash)
+	}
+	for _, in := range []struct {
+		column string
+		values []string
+	}{
+		{"feedback_type", filter.FeedbackTypes},
+		{"offer_payment_method_slug", filter.OfferPaymentMethodSlugs},
+		{"offer_fiat_code", filter.OfferFiatCodes},
+		{"offer_crypto_code", filter.OfferCryptoCodes},
+		{"offer_type", filter.OfferTypes},
+		{"trade_status", filter.TradeStatuses},
+	} {
+		if len(in.values) == 0 {
+			continue
+		}
+		where += " AND " + in.column + " IN (?" + strings.Repeat(", ?", len(in.values)-1) + ")"
+		for _, value := range in.values {
+			args = append(args, value)
+		}
+	}
+	if filter.CreatedFrom != "" {
+		where += " AND created_at >= ?"
+		args = append(args, filter.CreatedFrom)
+	}
+	if filter.CreatedTo != "" {
+		where += " AND created_at < ?"
+		args = append(args, filter.CreatedTo)
+	}
+	if filter.AmountMin != "" {
+		where += " AND trade_fiat_amount_requested_in_usd >= ?"
+		args = append(args, filter.AmountMin)
+	}
+	if filter.AmountMax != "" {
+		where += " AND trade_fiat_amount_requested_in_usd <= ?"
+		args = append(args, filter.AmountMax)
+	}
+	if filter.Query != "" {
+		where += " AND MATCH(message) AGAINST(? IN NATURAL LANGUAGE MODE)"
+		args = append(args, filter.Query)
 	}
 
-	var cnt int
-	countSql := fmt.Sprintf(sql, "COUNT(*)")
-	result := r.db.QueryRow(countSql)
-	err := result.Scan(&cnt)
-	if err != nil {
-		return nil, err
+	response := repository.FeedbackResponse{
+		Offser: filter.Offset,
+		Limit:  filter.Limit,
 	}
 
-	sql += fmt.Sprintf(" ORDER BY created_at DESC LIMIT %d, %d", filter.Offset, filter.Limit)
+	if !filter.SkipTotal {
+		var cnt int
+		result := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM feedbacks WHERE "+where, args...)
+		err := result.Scan(&cnt)
+		if err != nil {
+			return nil, err
+		}
+		response.Total = &cnt
+	}
+
+	sort := filter.Sort
+	if sort == nil {
+		sort = repository.DefaultSort
+	}
+	sortColumn, ok := sortColumns[sort.Field]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field %q", sort.Field)
+	}
+	direction, comparison := "ASC", ">"
+	if sort.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	orderArgs := make([]interface{}, 0)
+	if sort.Field == repository.SortRelevance {
+		if filter.Query == "" || filter.Cursor != nil {
+			return nil, errors.New("relevance order requires a search query and offset pagination")
+		}
+		orderArgs = append(orderArgs, filter.Query)
+	}
+
+	var limit string
+	if filter.Cursor != nil {
+		// NULL is the lowest value, MySQL sorts it first in ASC and last in DESC
+		switch {
+		case filter.Cursor.Null && sort.Desc:
+			where += fmt.Sprintf(" AND %s IS NULL AND id < ?", sortColumn)
+			args = append(args, filter.Cursor.ID)
+		case filter.Cursor.Null:
+			where += fmt.Sprintf(" AND (%s IS NOT NULL OR id > ?)", sortColumn)
+			args = append(args, filter.Cursor.ID)
+		default:
+			var nulls string
+			if sort.Desc && sort.Nullable() {
+				nulls = fmt.Sprintf(" OR %s IS NULL", sortColumn)
+			}
+			where += fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?)%[3]s)", sortColumn, comparison, nulls)
+			args = append(args, filter.Cursor.Value, filter.Cursor.Value, filter.Cursor.ID)
+		}
+		args = append(args, orderArgs...)
+		limit = "LIMIT ?"
+		args = append(args, filter.Limit+1)
+	} else {
+		args = append(args, orderArgs...)
+		limit = "LIMIT ?, ?"
+		args = append(args, filter.Offset, filter.Limit+1)
+	}
 
-	results, err := r.db.Query(fmt.Sprintf(sql, "*"))
+	query := fmt.Sprintf("SELECT %[1]s FROM feedbacks WHERE %[2]s ORDER BY %[3]s %[4]s, id %[4]s %[5]s", feedbackColumns, where, sortColumn, direction, limit)
+	results, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer results.Close()
 
 	for results.Next() {
-		var feedback repository.Feedback
-
-		err = results.Scan(
-			&feedback.ID,
-			&feedback.ParentId,
-			&feedback.SenderUuid,
-			&feedback.SenderName,
-			&feedback.SenderAvatar,
-			&feedback.ReceiverUuid,
-			&feedback.ReceiverName,
-			&feedback.ReceiverAvatar,
-			&feedback.OfferHash,
-			&feedback.OfferAthorized,
-			&feedback.OfferOwnerUuid,
-			&feedback.OfferType,
-			&feedback.OfferPaymentMethod,
-			&feedback.OfferPaymentMethodSlug,
-			&feedback.OfferFiatCode,
-			&feedback.OfferCryptoCode,
-			&feedback.OfferDeletedAt,
-			&feedback.TradeHash,
-			&feedback.TradeFiatAmountRequestedInUsd,
-			&feedback.TradeStatus,
-			&feedback.Message,
-			&feedback.FeedbackType,
-			&feedback.CreatedAt,
-			&feedback.UpdatedAt,
-			&feedback.DeletedAt,
-		)
+		feedback, err := scanFeedback(results)
 		if err != nil {
 			return nil, err
 		}
 
-		feedbacks = append(feedbacks, &feedback)
+		feedbacks = append(feedbacks, feedback)
 	}
 
-	response := repository.FeedbackResponse{
-		Total:  cnt,
-		Items:  feedbacks,
-		Offser: filter.Offset,
-		Limit:  filter.Limit,
+	// one extra row is fetched to know whether the next page exists
+	if len(feedbacks) > filter.Limit {
+		feedbacks = feedbacks[:filter.Limit]
+		if len(feedbacks) > 0 && sort.Field != repository.SortRelevance {
+			last := feedbacks[len(feedbacks)-1]
+			response.NextCursor = repository.NewCursor(sort, last).Encode()
+		}
 	}
+	response.Items = feedbacks
 
 	return &response, nil
 }
 
-func (r *mysqlRepository) Create(request *repository.CreateRequest) (int, error) {
-	tx, err := r.db.Begin()
+func (r *mysqlRepository) FindThread(ctx context.Context, id int, depth int, withTrashed bool) (*repository.FeedbackThread, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	trashedCondition := " AND deleted_at IS NULL"
+	if withTrashed {
+		trashedCondition = ""
+	}
+
+	rootQuery := "SELECT " + feedbackColumns + " FROM feedbacks WHERE id = ?" + trashedCondition
+	feedback, err := scanFeedback(r.db.QueryRowContext(ctx, rootQuery, id))
+	if err != nil {
+		return nil, err
+	}
+
+	root := &repository.FeedbackThread{Feedback: feedback, Replies: []*repository.FeedbackThread{}}
+	level := map[int]*repository.FeedbackThread{root.ID: root}
+
+	for i := 0; i < depth && len(level) > 0; i++ {
+		placeholders := make([]string, 0, len(level))
+		args := make([]interface{}, 0, len(level))
+		for parentId := range level {
+			placeholders = append(placeholders, "?")
+			args = append(args, parentId)
+		}
+
+		query := "SELECT " + feedbackColumns + " FROM feedbacks WHERE parent_id IN (" + strings.Join(placeholders, ", ") + ")" + trashedCondition + " ORDER BY created_at, id"
+		results, err := r.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		nextLevel := make(map[int]*repository.FeedbackThread)
+		for results.Next() {
+			reply, err := scanFeedback(results)
+			if err != nil {
+				results.Close()
+				return nil, err
+			}
+
+			node := &repository.FeedbackThread{Feedback: reply, Replies: []*repository.FeedbackThread{}}
+			parent := level[int(reply.ParentId.Int64)]
+			parent.Replies = append(parent.Replies, node)
+			nextLevel[reply.ID] = node
+		}
+		err = results.Err()
+		results.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		level = nextLevel
+	}
+
+	return root, nil
+}
+
+func (r *mysqlRepository) Create(ctx context.Context, request *repository.CreateRequest) (id int, err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	log.Println("transaction start")
 	if err != nil {
 		return 0, err
@@ -201,19 +326,25 @@ func (r *mysqlRepository) Create(request *repository.CreateRequest) (int, error)
 		err = tx.Commit()
 	}()
 
-	const queryTemplate string = "INSERT INTO feedbacks(parent_id, sender_uuid, sender_name, sender_avater, receiver_uuid, receiver_name, receiver_avater, offer_hash, offer_authorized, offer_owner_uuid, offer_type, offer_payment_method, offer_payment_method_slug, offer_fiat_code, offer_crypto_code, trade_hash, trade_fiat_amount_requested_in_usd, trade_status, message, feedback_type, created_at) VALUES(%s, UUID_TO_BIN('%s'), '%s', '%s', UUID_TO_BIN('%s'), '%s', '%s', '%s', %t, UUID_TO_BIN('%s'), '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s', %s)"
+	const queryTemplate string = "INSERT INTO feedbacks(parent_id, sender_uuid, sender_name, sender_avater, receiver_uuid, receiver_name, receiver_avater, offer_hash, offer_authorized, offer_owner_uuid, offer_type, offer_payment_method, offer_payment_method_slug, offer_fiat_code, offer_crypto_code, trade_hash, trade_fiat_amount_requested_in_usd, trade_status, message, feedback_type, created_at) VALUES(?, UUID_TO_BIN(?), ?, ?, UUID_TO_BIN(?), ?, ?, ?, ?, UUID_TO_BIN(?), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?, NOW()))"
 
-	parentId := "NULL"
+	var parentId interface{}
 	if request.ParentId > 0 {
-		parentId = strconv.Itoa(request.ParentId)
+		parentId = request.ParentId
 	}
 
-	createdAt := "NOW()"
+	var createdAt interface{}
 	if request.CreatedAt != "" {
-		createdAt = "'" + request.CreatedAt + "'"
+		createdAt = request.CreatedAt
 	}
 
-	sql := fmt.Sprintf(
+	var amount interface{}
+	if request.TradeFiatAmountRequestedInUsd != "" {
+		amount = request.TradeFiatAmountRequestedInUsd
+	}
+
+	res, err := tx.ExecContext(
+		ctx,
 		queryTemplate,
 		parentId,
 		request.SenderUuid,
@@ -231,25 +362,22 @@ func (r *mysqlRepository) Create(request *repository.CreateRequest) (int, error)
 		request.OfferFiatCode,
 		request.OfferCryptoCode,
 		request.TradeHash,
-		request.TradeFiatAmountRequestedInUsd,
+		amount,
 		request.TradeStatus,
 		request.Message,
 		request.FeedbackType,
 		createdAt,
 	)
-	log.Println(sql)
-
-	res, err := tx.Exec(sql)
 	if err != nil {
 		return 0, err
 	}
 
-	err = createStats(tx, request.ReceiverUuid)
+	err = createStats(ctx, tx, request.ReceiverUuid)
 	if err != nil {
 		return 0, err
 	}
 
-	err = updateStats(tx, request.ReceiverUuid, request.FeedbackType, true)
+	err = updateStats(ctx, tx, request.ReceiverUuid, request.FeedbackType, true)
 	if err != nil {
 		return 0, err
 	}
@@ -262,8 +390,11 @@ func (r *mysqlRepository) Create(request *repository.CreateRequest) (int, error)
 	return int(lastInsertedId), nil
 }
 
-func (r *mysqlRepository) Update(request *repository.UpdateRequest) error {
-	tx, err := r.db.Begin()
+func (r *mysqlRepository) Update(ctx context.Context, request *repository.UpdateRequest) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	log.Println("transaction start")
 	if err != nil {
 		return err
@@ -279,62 +410,32 @@ func (r *mysqlRepository) Update(request *repository.UpdateRequest) error {
 		err = tx.Commit()
 	}()
 
-	sql := fmt.Sprintf(
-		"SELECT id, parent_id, BIN_TO_UUID(sender_uuid), sender_name, sender_avater, BIN_TO_UUID(receiver_uuid), receiver_name, receiver_avater, offer_hash, offer_authorized, BIN_TO_UUID(offer_owner_uuid), offer_type, offer_payment_method, offer_payment_method_slug, offer_fiat_code, offer_crypto_code, offer_deleted_at, trade_hash, trade_fiat_amount_requested_in_usd, trade_status, message, feedback_type, created_at, updated_at, deleted_at FROM feedbacks WHERE sender_uuid = UUID_TO_BIN('%s') AND receiver_uuid = UUID_TO_BIN('%s') AND offer_payment_method_slug = '%s' AND offer_fiat_code = '%s'",
+	const selectTemplate string = "SELECT " + feedbackColumns + " FROM feedbacks WHERE sender_uuid = UUID_TO_BIN(?) AND receiver_uuid = UUID_TO_BIN(?) AND offer_payment_method_slug = ? AND offer_fiat_code = ? AND deleted_at IS NULL ORDER BY id LIMIT 1 FOR UPDATE"
+
+	var feedback *repository.Feedback
+	feedback, err = scanFeedback(tx.QueryRowContext(
+		ctx,
+		selectTemplate,
 		request.SenderUuid,
 		request.ReceiverUuid,
 		request.OfferPaymentMethodSlug,
 		request.OfferFiatCode,
-	)
-	log.Println(sql)
-
-	var feedback repository.Feedback
-	result := r.db.QueryRow(sql)
-
-	err = result.Scan(
-		&feedback.ID,
-		&feedback.ParentId,
-		&feedback.SenderUuid,
-		&feedback.SenderName,
-		&feedback.SenderAvatar,
-		&feedback.ReceiverUuid,
-		&feedback.ReceiverName,
-		&feedback.ReceiverAvatar,
-		&feedback.OfferHash,
-		&feedback.OfferAthorized,
-		&feedback.OfferOwnerUuid,
-		&feedback.OfferType,
-		&feedback.OfferPaymentMethod,
-		&feedback.OfferPaymentMethodSlug,
-		&feedback.OfferFiatCode,
-		&feedback.OfferCryptoCode,
-		&feedback.DeletedAt,
-		&feedback.TradeHash,
-		&feedback.TradeFiatAmountRequestedInUsd,
-		&feedback.TradeStatus,
-		&feedback.Message,
-		&feedback.FeedbackType,
-		&feedback.CreatedAt,
-		&feedback.UpdatedAt,
-		&feedback.DeletedAt,
-	)
+	))
 	if err != nil {
 		return err
 	}
 
-	const queryTemplate string = "UPDATE feedbacks SET message=\"%s\", feedback_type=\"%s\", updated_at=NOW() WHERE id=%d"
-
 	if request.Message != "" {
 		feedback.Message = request.Message
 	}
 
 	if request.FeedbackType != "" && request.FeedbackType != feedback.FeedbackType {
-		err = updateStats(tx, feedback.ReceiverUuid, feedback.FeedbackType, false)
+		err = updateStats(ctx, tx, feedback.ReceiverUuid, feedback.FeedbackType, false)
 		if err != nil {
 			return err
 		}
 
-		err = updateStats(tx, feedback.ReceiverUuid, request.FeedbackType, true)
+		err = updateStats(ctx, tx, feedback.ReceiverUuid, request.FeedbackType, true)
 		if err != nil {
 			return err
 		}
@@ -342,15 +443,9 @@ func (r *mysqlRepository) Update(request *repository.UpdateRequest) error {
 		feedback.FeedbackType = request.FeedbackType
 	}
 
-	sql = fmt.Sprintf(
-		queryTemplate,
-		feedback.Message,
-		feedback.FeedbackType,
-		feedback.ID,
-	)
-	log.Println(sql)
+	const updateTemplate string = "UPDATE feedbacks SET message = ?, feedback_type = ?, updated_at = NOW() WHERE id = ?"
 
-	_, err = r.db.Exec(sql)
+	_, err = tx.ExecContext(ctx, updateTemplate, feedback.Message, feedback.FeedbackType, feedback.ID)
 	if err != nil {
 		return err
 	}
@@ -358,8 +453,11 @@ func (r *mysqlRepository) Update(request *repository.UpdateRequest) error {
 	return nil
 }
 
-func (r *mysqlRepository) DeleteOffer(request *repository.DeleteOfferRequest) error {
-	tx, err := r.db.Begin()
+func (r *mysqlRepository) UpdateByID(ctx context.Context, id int, request *repository.PatchRequest) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	log.Println("transaction start")
 	if err != nil {
 		return err
@@ -375,36 +473,47 @@ func (r *mysqlRepository) DeleteOffer(request *repository.DeleteOfferRequest) er
 		err = tx.Commit()
 	}()
 
-	const queryTemplate string = "UPDATE feedbacks SET offer_deleted_at='%s' WHERE id=%d"
-
-	sql := fmt.Sprintf("SELECT id FROM feedbacks WHERE offer_hash='%s'", request.OfferHash)
-	log.Println(sql)
+	const selectTemplate string = "SELECT " + feedbackColumns + " FROM feedbacks WHERE id = ? AND deleted_at IS NULL FOR UPDATE"
 
-	results, err := r.db.Query(sql)
+	var feedback *repository.Feedback
+	feedback, err = scanFeedback(tx.QueryRowContext(ctx, selectTemplate, id))
 	if err != nil {
 		return err
 	}
 
-	var feedbackId int
-	for results.Next() {
-		err = results.Scan(&feedbackId)
+	if request.FeedbackType != "" && request.FeedbackType != feedback.FeedbackType {
+		err = updateStats(ctx, tx, feedback.ReceiverUuid, feedback.FeedbackType, false)
 		if err != nil {
 			return err
 		}
 
-		sql = fmt.Sprintf(queryTemplate, request.DeletedAt, feedbackId)
-		log.Println(sql)
-		_, err = r.db.Exec(sql)
+		err = updateStats(ctx, tx, feedback.ReceiverUuid, request.FeedbackType, true)
 		if err != nil {
 			return err
 		}
+
+		feedback.FeedbackType = request.FeedbackType
+	}
+
+	if request.Message != "" {
+		feedback.Message = request.Message
+	}
+
+	const updateTemplate string = "UPDATE feedbacks SET message = ?, feedback_type = ?, updated_at = NOW() WHERE id = ?"
+
+	_, err = tx.ExecContext(ctx, updateTemplate, feedback.Message, feedback.FeedbackType, feedback.ID)
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func (r *mysqlRepository) ChangeTradeStatus(request *repository.ChangeTradeStatusRequest) error {
-	tx, err := r.db.Begin()
+func (r *mysqlRepository) Delete(ctx context.Context, id int) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	log.Println("transaction start")
 	if err != nil {
 		return err
@@ -420,61 +529,304 @@ func (r *mysqlRepository) ChangeTradeStatus(request *repository.ChangeTradeStatu
 		err = tx.Commit()
 	}()
 
-	const queryTemplate string = "UPDATE feedbacks SET trade_status='%s' WHERE id=%d"
+	const selectTemplate string = "SELECT " + feedbackColumns + " FROM feedbacks WHERE id = ? AND deleted_at IS NULL FOR UPDATE"
 
-	sql := fmt.Sprintf("SELECT id FROM feedbacks WHERE trade_hash='%s'", request.TradeHash)
-	log.Println(sql)
+	var feedback *repository.Feedback
+	feedback, err = scanFeedback(tx.QueryRowContext(ctx, selectTemplate, id))
+	if err != nil {
+		return err
+	}
 
-	results, err := r.db.Query(sql)
+	_, err = tx.ExecContext(ctx, "UPDATE feedbacks SET deleted_at = NOW() WHERE id = ?", feedback.ID)
 	if err != nil {
 		return err
 	}
 
-	var feedbackId int
-	for results.Next() {
-		err = results.Scan(&feedbackId)
+	err = updateStats(ctx, tx, feedback.ReceiverUuid, feedback.FeedbackType, false)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *mysqlRepository) Restore(ctx context.Context, id int) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	log.Println("transaction start")
+	if err != nil {
+		return err
+	}
+	defer func() {
 		if err != nil {
-			return err
+			log.Println(err.Error())
+			log.Println("rollback")
+			tx.Rollback()
+			return
+		}
+		log.Println("commit")
+		err = tx.Commit()
+	}()
+
+	const selectTemplate string = "SELECT " + feedbackColumns + " FROM feedbacks WHERE id = ? AND deleted_at IS NOT NULL FOR UPDATE"
+
+	var feedback *repository.Feedback
+	feedback, err = scanFeedback(tx.QueryRowContext(ctx, selectTemplate, id))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE feedbacks SET deleted_at = NULL WHERE id = ?", feedback.ID)
+	if err != nil {
+		return err
+	}
+
+	err = createStats(ctx, tx, feedback.ReceiverUuid)
+	if err != nil {
+		return err
+	}
+
+	err = updateStats(ctx, tx, feedback.ReceiverUuid, feedback.FeedbackType, true)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *mysqlRepository) DeleteOffer(ctx context.Context, request *repository.DeleteOfferRequest) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	log.Println("transaction start")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			log.Println(err.Error())
+			log.Println("rollback")
+			tx.Rollback()
+			return
 		}
+		log.Println("commit")
+		err = tx.Commit()
+	}()
+
+	const queryTemplate string = "UPDATE feedbacks SET offer_deleted_at = ?, updated_at = NOW() WHERE offer_hash = ?"
+
+	_, err = tx.ExecContext(ctx, queryTemplate, request.DeletedAt, request.OfferHash)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *mysqlRepository) ChangeTradeStatus(ctx context.Context, request *repository.ChangeTradeStatusRequest) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-		sql = fmt.Sprintf(queryTemplate, request.TradeStatus, feedbackId)
-		log.Println(sql)
-		_, err = r.db.Exec(sql)
+	tx, err := r.db.BeginTx(ctx, nil)
+	log.Println("transaction start")
+	if err != nil {
+		return err
+	}
+	defer func() {
 		if err != nil {
-			return err
+			log.Println(err.Error())
+			log.Println("rollback")
+			tx.Rollback()
+			return
 		}
+		log.Println("commit")
+		err = tx.Commit()
+	}()
+
+	const queryTemplate string = "UPDATE feedbacks SET trade_status = ?, updated_at = NOW() WHERE trade_hash = ?"
+
+	_, err = tx.ExecContext(ctx, queryTemplate, request.TradeStatus, request.TradeHash)
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func createStats(tx *sql.Tx, userUuid string) error {
-	log.Println("checking for stats")
+func (r *mysqlRepository) GetStats(ctx context.Context, userUuid string) (*repository.Stats, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	// TODO: qoute param value
-	row := tx.QueryRow("SELECT BIN_TO_UUID(user_uuid) FROM feedback_stats WHERE BIN_TO_UUID(user_uuid)=?", userUuid)
-	var dbData string
-	err := row.Scan(&dbData)
+	const queryTemplate string = "SELECT COALESCE(positive, 0), COALESCE(negative, 0), COALESCE(initial, 0) FROM feedback_stats WHERE user_uuid = UUID_TO_BIN(?)"
+
+	var positive, negative, initial int
+	err := r.db.QueryRowContext(ctx, queryTemplate, userUuid).Scan(&positive, &negative, &initial)
 	if err != nil && err != sql.ErrNoRows {
-		return err
+		return nil, err
 	}
 
-	if dbData == userUuid {
-		log.Println("have found")
-		return nil
+	return repository.NewStats(userUuid, positive, negative, initial), nil
+}
+
+// RebuildStats recounts the counters of every user from the feedbacks left
+// after soft deletes. Each batch of users gets its own transaction and
+// timeout, so the writers of a live database wait for one batch at most.
+func (r *mysqlRepository) RebuildStats(ctx context.Context, request *repository.RebuildStatsRequest) ([]*repository.StatsDiff, error) {
+	batchSize := request.BatchSize
+	if batchSize <= 0 {
+		batchSize = repository.DefaultStatsBatchSize
 	}
 
-	log.Println("didn't find")
+	diffs := make([]*repository.StatsDiff, 0)
+	after := ""
+	for {
+		users, err := r.statsUsers(ctx, after, batchSize)
+		if err != nil {
+			return diffs, err
+		}
+		if len(users) == 0 {
+			return diffs, nil
+		}
 
-	const queryTemplate string = "INSERT INTO feedback_stats (user_uuid) VALUES(UUID_TO_BIN('%s'))"
+		batch, err := r.rebuildStats(ctx, users, request.DryRun)
+		if err != nil {
+			return diffs, err
+		}
+		diffs = append(diffs, batch...)
 
-	sql := fmt.Sprintf(
-		queryTemplate,
-		userUuid,
-	)
-	log.Println(sql)
+		after = users[len(users)-1]
+	}
+}
+
+// statsUsers lists the next users having feedbacks or counters ordered by uuid
+func (r *mysqlRepository) statsUsers(ctx context.Context, after string, limit int) ([]string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT BIN_TO_UUID(user_uuid) FROM (SELECT receiver_uuid AS user_uuid FROM feedbacks UNION SELECT user_uuid FROM feedback_stats) AS users"
+	args := make([]interface{}, 0)
+	if after != "" {
+		query += " WHERE user_uuid > UUID_TO_BIN(?)"
+		args = append(args, after)
+	}
+	query += " ORDER BY user_uuid LIMIT ?"
+	args = append(args, limit)
+
+	results, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	users := make([]string, 0, limit)
+	for results.Next() {
+		var userUuid string
+		err = results.Scan(&userUuid)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, userUuid)
+	}
 
-	_, err = tx.Exec(sql)
+	return users, results.Err()
+}
+
+// rebuildStats fixes the counters of the users. They are locked before the
+// feedbacks are counted, so a concurrent create is either counted already or
+// waits and increments the fixed counters.
+func (r *mysqlRepository) rebuildStats(ctx context.Context, users []string, dryRun bool) (diffs []*repository.StatsDiff, err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	log.Println("transaction start")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			log.Println(err.Error())
+			log.Println("rollback")
+			tx.Rollback()
+			return
+		}
+		log.Println("commit")
+		err = tx.Commit()
+	}()
+
+	placeholders := "UUID_TO_BIN(?)" + strings.Repeat(", UUID_TO_BIN(?)", len(users)-1)
+	args := make([]interface{}, 0, len(users))
+	for _, userUuid := range users {
+		args = append(args, userUuid)
+	}
+
+	results, err := tx.QueryContext(ctx, "SELECT BIN_TO_UUID(user_uuid), COALESCE(positive, 0), COALESCE(negative, 0) FROM feedback_stats WHERE user_uuid IN ("+placeholders+") FOR UPDATE", args...)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := scanStatsCounts(results)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err = tx.QueryContext(ctx, "SELECT BIN_TO_UUID(receiver_uuid), COUNT(CASE WHEN feedback_type = 'POSITIVE' THEN 1 END), COUNT(CASE WHEN feedback_type = 'NEGATIVE' THEN 1 END) FROM feedbacks WHERE deleted_at IS NULL AND receiver_uuid IN ("+placeholders+") GROUP BY receiver_uuid", args...)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := scanStatsCounts(results)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs = repository.NewStatsDiffs(users, stored, expected)
+	if dryRun {
+		return diffs, nil
+	}
+
+	const queryTemplate string = "INSERT INTO feedback_stats (user_uuid, positive, negative) VALUES(UUID_TO_BIN(?), ?, ?) ON DUPLICATE KEY UPDATE positive = VALUES(positive), negative = VALUES(negative)"
+
+	for _, diff := range diffs {
+		_, err = tx.ExecContext(ctx, queryTemplate, diff.UserUuid, diff.Expected.Positive, diff.Expected.Negative)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return diffs, nil
+}
+
+func scanStatsCounts(results *sql.Rows) (map[string]repository.StatsCounts, error) {
+	defer results.Close()
+
+	counts := make(map[string]repository.StatsCounts)
+	for results.Next() {
+		var userUuid string
+		var userCounts repository.StatsCounts
+		err := results.Scan(&userUuid, &userCounts.Positive, &userCounts.Negative)
+		if err != nil {
+			return nil, err
+		}
+		counts[userUuid] = userCounts
+	}
+
+	return counts, results.Err()
+}
+
+// statsColumns whitelists the feedback_stats counters a feedback type maps to
+var statsColumns = map[string]string{
+	"POSITIVE": "positive",
+	"NEGATIVE": "negative",
+}
+
+// createStats makes sure the counters row of the user exists, concurrent
+// creates of the same user meet on the unique index instead of failing
+func createStats(ctx context.Context, tx *sql.Tx, userUuid string) error {
+	const queryTemplate string = "INSERT INTO feedback_stats (user_uuid) VALUES(UUID_TO_BIN(?)) ON DUPLICATE KEY UPDATE user_uuid = user_uuid"
+
+	_, err := tx.ExecContext(ctx, queryTemplate, userUuid)
 	if err != nil {
 		return err
 	}
@@ -482,26 +834,66 @@ func createStats(tx *sql.Tx, userUuid string) error {
 	return nil
 }
 
-func updateStats(tx *sql.Tx, userUuid string, feedbackType string, isIncrease bool) error {
+func updateStats(ctx context.Context, tx *sql.Tx, userUuid string, feedbackType string, isIncrease bool) error {
+	column, ok := statsColumns[feedbackType]
+	if !ok {
+		return fmt.Errorf("unknown feedback type %q", feedbackType)
+	}
+
 	var queryTemplate string
 	if isIncrease {
-		queryTemplate = "UPDATE feedback_stats SET %s = %s + 1 WHERE user_uuid=UUID_TO_BIN('%s')"
+		queryTemplate = "UPDATE feedback_stats SET %[1]s = %[1]s + 1 WHERE user_uuid = UUID_TO_BIN(?)"
 	} else {
-		queryTemplate = "UPDATE feedback_stats SET %s = %s - 1 WHERE user_uuid=UUID_TO_BIN('%s')"
+		queryTemplate = "UPDATE feedback_stats SET %[1]s = %[1]s - 1 WHERE user_uuid = UUID_TO_BIN(?)"
 	}
 
-	sql := fmt.Sprintf(
-		queryTemplate,
-		feedbackType,
-		feedbackType,
-		userUuid,
-	)
-	log.Println(sql)
-
-	_, err := tx.Exec(sql)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(queryTemplate, column), userUuid)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFeedback(row scanner) (*repository.Feedback, error) {
+	var feedback repository.Feedback
+	// the amount of the old feedbacks may be NULL, it is left empty then
+	var amount sql.NullString
+	err := row.Scan(
+		&feedback.ID,
+		&feedback.ParentId,
+		&feedback.SenderUuid,
+		&feedback.SenderName,
+		&feedback.SenderAvatar,
+		&feedback.ReceiverUuid,
+		&feedback.ReceiverName,
+		&feedback.ReceiverAvatar,
+		&feedback.OfferHash,
+		&feedback.OfferAthorized,
+		&feedback.OfferOwnerUuid,
+		&feedback.OfferType,
+		&feedback.OfferPaymentMethod,
+		&feedback.OfferPaymentMethodSlug,
+		&feedback.OfferFiatCode,
+		&feedback.OfferCryptoCode,
+		&feedback.OfferDeletedAt,
+		&feedback.TradeHash,
+		&amount,
+		&feedback.TradeStatus,
+		&feedback.Message,
+		&feedback.FeedbackType,
+		&feedback.CreatedAt,
+		&feedback.UpdatedAt,
+		&feedback.DeletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	feedback.TradeFiatAmountRequestedInUsd = amount.String
+
+	return &feedback, nil
+}
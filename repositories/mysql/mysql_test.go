@@ -0,0 +1,297 @@
+package mysqlrepository
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	repository "feedback-service-go/repositories"
+	"feedback-service-go/repositories/migrations"
+	"feedback-service-go/repositories/repositorytest"
+)
+
+var ctx = context.Background()
+
+var hostileStrings = []string{
+	"O'Reilly",
+	`it's "quoted"`,
+	"'; DROP TABLE feedbacks; --",
+	"' OR '1'='1",
+	`\'; DELETE FROM feedback_stats; -- \`,
+	"%s %d %[1]s",
+	"?",
+	"ünïcødé ✓",
+}
+
+// newTestRepository connects to the database from MYSQL_TEST_DSN, e.g.
+// "db_user:secret@tcp(localhost:3306)/feedback_service", migrates and empties it
+func newTestRepository(t *testing.T) repository.Repository {
+	dsn := os.Getenv("MYSQL_TEST_DSN")
+	if dsn == "" {
+		t.Skip("MYSQL_TEST_DSN is not set")
+	}
+
+	repo, err := Open(dsn, 5*time.Second)
+	if err != nil {
+		t.Fatalf("can't connect to MySQL: %v", err)
+	}
+	t.Cleanup(repo.Close)
+
+	migrator, err := migrations.New(repo.GetDB(), "mysql")
+	if err != nil {
+		t.Fatalf("can't load the migrations: %v", err)
+	}
+	_, err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("can't migrate the database: %v", err)
+	}
+
+	for _, query := range []string{"DELETE FROM feedbacks", "DELETE FROM feedback_stats"} {
+		_, err = repo.GetDB().Exec(query)
+		if err != nil {
+			t.Fatalf("can't clean up the database: %v", err)
+		}
+	}
+
+	return repo
+}
+
+func newCreateRequest(message string) *repository.CreateRequest {
+	return &repository.CreateRequest{
+		SenderUuid:                    "807a51d6-a81b-4b66-9596-5b17ea26b136",
+		SenderName:                    "sender#1",
+		SenderAvatar:                  "sender#1 avatar",
+		ReceiverUuid:                  "807a51d6-a81b-4b66-9596-5b17ea26b137",
+		ReceiverName:                  "receiver#1",
+		ReceiverAvatar:                "receiver#1 avatar",
+		OfferHash:                     "ksO3jso7aDi",
+		OfferAthorized:                true,
+		OfferOwnerUuid:                "807a51d6-a81b-4b66-9596-5b17ea26b138",
+		OfferType:                     "SELL",
+		OfferPaymentMethod:            "PayPal",
+		OfferPaymentMethodSlug:        "paypal_slug",
+		OfferFiatCode:                 "RUB",
+		OfferCryptoCode:               "BTC",
+		TradeHash:                     "isO9AlIU8s2",
+		TradeFiatAmountRequestedInUsd: "320.12",
+		TradeStatus:                   "RELEASED",
+		Message:                       message,
+		FeedbackType:                  "POSITIVE",
+	}
+}
+
+// assertIntact checks that the tables survived and the receiver's stats are consistent
+func assertIntact(t *testing.T, repo repository.Repository, total int, positive int) {
+	t.Helper()
+
+	response, err := repo.Find(ctx, &repository.RequestFilter{Limit: 100})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if *response.Total != total {
+		t.Errorf("expected %d feedbacks, got %d", total, *response.Total)
+	}
+
+	stats, err := repo.GetStats(ctx, "807a51d6-a81b-4b66-9596-5b17ea26b137")
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if stats.Positive != positive {
+		t.Errorf("expected %d positive feedbacks, got %d", positive, stats.Positive)
+	}
+}
+
+func TestRepositorySuite(t *testing.T) {
+	repositorytest.RunSuite(t, newTestRepository)
+}
+
+func TestHostileStringsAreStoredVerbatim(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for i, hostile := range hostileStrings {
+		request := newCreateRequest(hostile)
+		request.SenderName = hostile
+		request.OfferPaymentMethod = hostile
+
+		id, err := repo.Create(ctx, request)
+		if err != nil {
+			t.Fatalf("Create(%q) failed: %v", hostile, err)
+		}
+
+		feedback, err := repo.FindByID(ctx, id)
+		if err != nil {
+			t.Fatalf("FindByID(%d) failed: %v", id, err)
+		}
+		if feedback.Message != hostile || feedback.SenderName != hostile || feedback.OfferPaymentMethod != hostile {
+			t.Errorf("expected %q to be stored verbatim, got %q, %q, %q", hostile, feedback.Message, feedback.SenderName, feedback.OfferPaymentMethod)
+		}
+
+		err = repo.UpdateByID(ctx, id, &repository.PatchRequest{Message: hostile + " edited"})
+		if err != nil {
+			t.Fatalf("UpdateByID(%q) failed: %v", hostile, err)
+		}
+
+		feedback, err = repo.FindByID(ctx, id)
+		if err != nil {
+			t.Fatalf("FindByID(%d) failed: %v", id, err)
+		}
+		if feedback.Message != hostile+" edited" {
+			t.Errorf("expected %q, got %q", hostile+" edited", feedback.Message)
+		}
+
+		assertIntact(t, repo, i+1, i+1)
+	}
+}
+
+func TestHostileStringsInFilters(t *testing.T) {
+	repo := newTestRepository(t)
+
+	_, err := repo.Create(ctx, newCreateRequest("text message"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	for _, hostile := range hostileStrings {
+		response, err := repo.Find(ctx, &repository.RequestFilter{
+			OfferHash:               hostile,
+			TradeHash:               hostile,
+			OfferPaymentMethodSlugs: []string{hostile, "paypal_slug"},
+			OfferCryptoCodes:        []string{hostile, "BTC"},
+			Limit:                   10,
+		})
+		if err != nil {
+			t.Fatalf("Find(%q) failed: %v", hostile, err)
+		}
+		if len(response.Items) != 0 {
+			t.Errorf("Find(%q) expected no items, got %d", hostile, len(response.Items))
+		}
+
+		// the REST handler rejects malformed UUIDs earlier, here UUID_TO_BIN does; the point is that nothing else happens
+		repo.Find(ctx, &repository.RequestFilter{SenderUuid: hostile, ReceiverUuid: hostile, Limit: 10})
+
+		assertIntact(t, repo, 1, 1)
+	}
+}
+
+func TestHostileStringsInWrites(t *testing.T) {
+	repo := newTestRepository(t)
+
+	_, err := repo.Create(ctx, newCreateRequest("text message"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	for _, hostile := range hostileStrings {
+		err = repo.Update(ctx, &repository.UpdateRequest{
+			SenderUuid:             "807a51d6-a81b-4b66-9596-5b17ea26b136",
+			ReceiverUuid:           "807a51d6-a81b-4b66-9596-5b17ea26b137",
+			OfferPaymentMethodSlug: "paypal_slug",
+			OfferFiatCode:          "RUB",
+			Message:                hostile,
+		})
+		if err != nil {
+			t.Fatalf("Update(%q) failed: %v", hostile, err)
+		}
+
+		response, err := repo.Find(ctx, &repository.RequestFilter{Limit: 10})
+		if err != nil {
+			t.Fatalf("Find failed: %v", err)
+		}
+		if response.Items[0].Message != hostile {
+			t.Errorf("expected %q, got %q", hostile, response.Items[0].Message)
+		}
+
+		err = repo.Update(ctx, &repository.UpdateRequest{
+			SenderUuid:             "807a51d6-a81b-4b66-9596-5b17ea26b136",
+			ReceiverUuid:           "807a51d6-a81b-4b66-9596-5b17ea26b137",
+			OfferPaymentMethodSlug: hostile,
+			OfferFiatCode:          "RUB",
+			Message:                "never stored",
+		})
+		if err == nil {
+			t.Errorf("Update by the %q slug expected to find nothing", hostile)
+		}
+
+		err = repo.DeleteOffer(ctx, &repository.DeleteOfferRequest{OfferHash: hostile, DeletedAt: "2021-09-06 05:01:43"})
+		if err != nil {
+			t.Fatalf("DeleteOffer(%q) failed: %v", hostile, err)
+		}
+
+		// ENUM columns refuse unknown values in the strict mode, the table must stay as is
+		repo.ChangeTradeStatus(ctx, &repository.ChangeTradeStatusRequest{TradeHash: "isO9AlIU8s2", TradeStatus: hostile})
+		err = repo.ChangeTradeStatus(ctx, &repository.ChangeTradeStatusRequest{TradeHash: hostile, TradeStatus: "DISPUTED"})
+		if err != nil {
+			t.Fatalf("ChangeTradeStatus(%q) failed: %v", hostile, err)
+		}
+
+		stats, err := repo.GetStats(ctx, hostile)
+		if err == nil && stats.Total != 0 {
+			t.Errorf("GetStats(%q) expected no stats, got %d", hostile, stats.Total)
+		}
+
+		assertIntact(t, repo, 1, 1)
+	}
+
+	response, err := repo.Find(ctx, &repository.RequestFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if response.Items[0].OfferDeletedAt.Valid || response.Items[0].TradeStatus != "RELEASED" {
+		t.Errorf("expected the feedback to stay untouched, got %+v", response.Items[0])
+	}
+}
+
+func TestUpdateStatsRejectsUnknownColumns(t *testing.T) {
+	for _, hostile := range append(hostileStrings, "positive", "initial", "user_uuid") {
+		err := updateStats(context.Background(), nil, "807a51d6-a81b-4b66-9596-5b17ea26b137", hostile, true)
+		if err == nil {
+			t.Errorf("updateStats(%q) expected to fail", hostile)
+		}
+	}
+}
+
+// TestAdoptsInitSqlSchema rebuilds the database the old init.sql left behind,
+// the tables without schema_migrations, and checks migrate up completes it
+func TestAdoptsInitSqlSchema(t *testing.T) {
+	repo := newTestRepository(t)
+
+	migrator, err := migrations.New(repo.GetDB(), "mysql")
+	if err != nil {
+		t.Fatalf("can't load the migrations: %v", err)
+	}
+	list, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("can't list the migrations: %v", err)
+	}
+	// 0001 is the init.sql schema
+	_, err = migrator.Down(ctx, len(list)-1)
+	if err != nil {
+		t.Fatalf("can't revert to the init.sql schema: %v", err)
+	}
+	_, err = repo.GetDB().Exec("DROP TABLE schema_migrations")
+	if err != nil {
+		t.Fatalf("can't forget the applied migrations: %v", err)
+	}
+
+	_, err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("migrate up of the init.sql schema failed: %v", err)
+	}
+	err = migrator.Check(ctx)
+	if err != nil {
+		t.Fatalf("expected the adopted schema to be up to date, got %v", err)
+	}
+
+	_, err = repo.Create(ctx, newCreateRequest("scam"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	response, err := repo.Find(ctx, &repository.RequestFilter{Query: "scam", Limit: 10, Sort: &repository.Sort{Field: repository.SortRelevance, Desc: true}})
+	if err != nil {
+		t.Fatalf("the full-text search of the adopted schema failed: %v", err)
+	}
+	if len(response.Items) != 1 {
+		t.Errorf("expected the feedback to be found, got %d", len(response.Items))
+	}
+}
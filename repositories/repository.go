@@ -1,11 +1,16 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/url"
 	"reflect"
-	"time"
+	"strings"
 )
 
 type CreateRequest struct {
@@ -32,6 +37,89 @@ type CreateRequest struct {
 	CreatedAt                     string `json:"created_at"`
 }
 
+func (request *CreateRequest) Validate() url.Values {
+	errs := url.Values{}
+
+	if request.ParentId < 0 {
+		errs.Add("parent_id", "The parent_id field must be a positive number!")
+	}
+
+	for field, value := range map[string]string{
+		"sender_uuid":      request.SenderUuid,
+		"receiver_uuid":    request.ReceiverUuid,
+		"offer_owner_uuid": request.OfferOwnerUuid,
+	} {
+		if !IsUuid(value) {
+			errs.Add(field, "The "+field+" field is required and must be a UUID!")
+		}
+	}
+
+	if request.SenderUuid != "" && request.SenderUuid == request.ReceiverUuid {
+		errs.Add("receiver_uuid", "The receiver_uuid field must differ from the sender_uuid one!")
+	}
+
+	for field, value := range map[string]string{
+		"offer_hash": request.OfferHash,
+		"trade_hash": request.TradeHash,
+	} {
+		if !IsHash(value) {
+			errs.Add(field, "The "+field+" field is required and must be 11 alphanumeric chars!")
+		}
+	}
+
+	for _, text := range []struct {
+		field     string
+		value     string
+		maxLength int
+	}{
+		{"sender_name", request.SenderName, 64},
+		{"sender_avatar", request.SenderAvatar, 128},
+		{"receiver_name", request.ReceiverName, 16},
+		{"receiver_avatar", request.ReceiverAvatar, 128},
+		{"offer_payment_method", request.OfferPaymentMethod, 64},
+		{"offer_payment_method_slug", request.OfferPaymentMethodSlug, 64},
+		{"offer_crypto_code", request.OfferCryptoCode, 12},
+	} {
+		if len([]rune(text.value)) > text.maxLength {
+			errs.Add(text.field, fmt.Sprintf("The %s field must not be longer than %d chars!", text.field, text.maxLength))
+		}
+	}
+
+	if request.OfferPaymentMethodSlug == "" {
+		errs.Add("offer_payment_method_slug", "The offer_payment_method_slug field is required!")
+	}
+
+	if !IsOneOf(request.OfferType, OfferTypes) {
+		errs.Add("offer_type", "The offer_type field must be one of "+strings.Join(OfferTypes, ", ")+"!")
+	}
+
+	if !IsOneOf(request.OfferFiatCode, FiatCodes) {
+		errs.Add("offer_fiat_code", "The offer_fiat_code field must be one of "+strings.Join(FiatCodes, ", ")+"!")
+	}
+
+	if !IsAmount(request.TradeFiatAmountRequestedInUsd) {
+		errs.Add("trade_fiat_amount_requested_in_usd", "The trade_fiat_amount_requested_in_usd field must be a decimal with up to 8 integer and 2 fractional digits!")
+	}
+
+	if !IsOneOf(request.TradeStatus, TradeStatuses) {
+		errs.Add("trade_status", "The trade_status field must be one of "+strings.Join(TradeStatuses, ", ")+"!")
+	}
+
+	if request.Message == "" {
+		errs.Add("message", "The message field is required!")
+	}
+
+	if !IsOneOf(request.FeedbackType, FeedbackTypes) {
+		errs.Add("feedback_type", "The feedback_type field must be one of "+strings.Join(FeedbackTypes, ", ")+"!")
+	}
+
+	if request.CreatedAt != "" && !IsDateTime(request.CreatedAt) {
+		errs.Add("created_at", "The created_at field must be formatted as '"+DateTimeLayout+"'!")
+	}
+
+	return errs
+}
+
 type UpdateRequest struct {
 	SenderUuid             string `json:"sender_uuid"`
 	ReceiverUuid           string `json:"receiver_uuid"`
@@ -41,72 +129,110 @@ type UpdateRequest struct {
 	FeedbackType           string `json:"feedback_type"`
 }
 
-type DeleteOfferRequest struct {
-	OfferHash string `json:"offer_hash"`
-	DeletedAt string `json:"deleted_at"`
-}
+func (request *UpdateRequest) Validate() url.Values {
+	errs := url.Values{}
 
-type ChangeTradeStatusRequest struct {
-	TradeHash   string `json:"trade_hash"`
-	TradeStatus string `json:"trade_status"`
+	if !IsUuid(request.SenderUuid) {
+		errs.Add("sender_uuid", "The sender_uuid field is required and must be a UUID!")
+	}
+
+	if !IsUuid(request.ReceiverUuid) {
+		errs.Add("receiver_uuid", "The receiver_uuid field is required and must be a UUID!")
+	}
+
+	if request.OfferPaymentMethodSlug == "" {
+		errs.Add("offer_payment_method_slug", "The offer_payment_method_slug field is required!")
+	}
+
+	if !IsOneOf(request.OfferFiatCode, FiatCodes) {
+		errs.Add("offer_fiat_code", "The offer_fiat_code field must be one of "+strings.Join(FiatCodes, ", ")+"!")
+	}
+
+	if request.Message == "" && request.FeedbackType == "" {
+		errs.Add("message", "Either the message or the feedback_type field is required!")
+	}
+
+	if request.FeedbackType != "" && !IsOneOf(request.FeedbackType, FeedbackTypes) {
+		errs.Add("feedback_type", "The feedback_type field must be one of "+strings.Join(FeedbackTypes, ", ")+"!")
+	}
+
+	return errs
 }
 
-type FeedbackRequest struct {
-	ParentId   int `json:"parent_id"`
-	SenderId   int `json:"sender_id"`
-	ReceiverId int `json:"receiver_id"`
-	TradeId    int `json:"trade_id"`
-	Message    string
-	Type       string
-	CreatedAt  string `json:"created_at"`
+type PatchRequest struct {
+	Message      string `json:"message"`
+	FeedbackType string `json:"feedback_type"`
 }
 
-func (request *FeedbackRequest) Validate() url.Values {
+func (request *PatchRequest) Validate() url.Values {
 	errs := url.Values{}
 
-	if request.ParentId < 0 {
-		errs.Add("parent_id", "The parent_id field must be a positive number!")
+	if request.Message == "" && request.FeedbackType == "" {
+		errs.Add("message", "Either the message or the feedback_type field is required!")
 	}
 
-	if request.SenderId < 1 {
-		errs.Add("sender_id", "The sender_id field is required and must be more then 0!")
+	if request.FeedbackType != "" && !IsOneOf(request.FeedbackType, FeedbackTypes) {
+		errs.Add("feedback_type", "The feedback_type field must be one of "+strings.Join(FeedbackTypes, ", ")+"!")
 	}
 
-	if request.ReceiverId < 1 {
-		errs.Add("receiver_id", "The receiver_id field is required and must be more then 0!")
-	}
+	return errs
+}
+
+type DeleteOfferRequest struct {
+	OfferHash string `json:"offer_hash"`
+	DeletedAt string `json:"deleted_at"`
+}
 
-	if request.TradeId < 1 {
-		errs.Add("trade_id", "The trade_id field is required and must be more then 0!")
+func (request *DeleteOfferRequest) Validate() url.Values {
+	errs := url.Values{}
+
+	if !IsHash(request.OfferHash) {
+		errs.Add("offer_hash", "The offer_hash field is required and must be 11 alphanumeric chars!")
 	}
 
-	if len(request.Message) < 5 {
-		errs.Add("message", "The message field must be longer than 5 chars!")
+	if !IsDateTime(request.DeletedAt) {
+		errs.Add("deleted_at", "The deleted_at field is required and must be formatted as '"+DateTimeLayout+"'!")
 	}
 
-	if request.Type != "positive" && request.Type != "negative" {
-		errs.Add("type", "The type field must be either 'positive' or 'negative'!")
+	return errs
+}
+
+type ChangeTradeStatusRequest struct {
+	TradeHash   string `json:"trade_hash"`
+	TradeStatus string `json:"trade_status"`
+}
+
+func (request *ChangeTradeStatusRequest) Validate() url.Values {
+	errs := url.Values{}
+
+	if !IsHash(request.TradeHash) {
+		errs.Add("trade_hash", "The trade_hash field is required and must be 11 alphanumeric chars!")
 	}
 
-	if request.CreatedAt != "" {
-		_, err := time.Parse("2006-01-02 15:04:05", request.CreatedAt)
-		if err != nil {
-			errs.Add("created_at", err.Error())
-		}
+	if !IsOneOf(request.TradeStatus, TradeStatuses) {
+		errs.Add("trade_status", "The trade_status field must be one of "+strings.Join(TradeStatuses, ", ")+"!")
 	}
 
 	return errs
 }
 
+// Repository is the storage of feedbacks. Every call is bound by the
+// context, the implementations add their own default timeouts on top.
 type Repository interface {
 	GetDB() *sql.DB
 	Close()
-	FindByID(id int) (*Feedback, error)
-	Find(filter *RequestFilter) (*FeedbackResponse, error)
-	Create(request *CreateRequest) (int, error)
-	Update(request *UpdateRequest) error
-	DeleteOffer(request *DeleteOfferRequest) error
-	ChangeTradeStatus(request *ChangeTradeStatusRequest) error
+	FindByID(ctx context.Context, id int) (*Feedback, error)
+	Find(ctx context.Context, filter *RequestFilter) (*FeedbackResponse, error)
+	FindThread(ctx context.Context, id int, depth int, withTrashed bool) (*FeedbackThread, error)
+	Create(ctx context.Context, request *CreateRequest) (int, error)
+	Update(ctx context.Context, request *UpdateRequest) error
+	UpdateByID(ctx context.Context, id int, request *PatchRequest) error
+	Delete(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int) error
+	DeleteOffer(ctx context.Context, request *DeleteOfferRequest) error
+	ChangeTradeStatus(ctx context.Context, request *ChangeTradeStatusRequest) error
+	GetStats(ctx context.Context, userUuid string) (*Stats, error)
+	RebuildStats(ctx context.Context, request *RebuildStatsRequest) ([]*StatsDiff, error)
 }
 
 type NullInt64 sql.NullInt64
@@ -183,18 +309,230 @@ type Feedback struct {
 }
 
 type FeedbackResponse struct {
-	Total  int         `json:"total"`
-	Items  []*Feedback `json:"items"`
-	Offser int         `json:"offset"`
-	Limit  int         `json:"limit"`
+	Total      *int        `json:"total,omitempty"`
+	Items      []*Feedback `json:"items"`
+	Offser     int         `json:"offset"`
+	Limit      int         `json:"limit"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+type FeedbackThread struct {
+	*Feedback
+	Replies []*FeedbackThread `json:"replies"`
 }
 
 type RequestFilter struct {
+	ParentId     int    `json:"parent_id"`
 	SenderUuid   string `json:"sender_uuid"`
 	ReceiverUuid string `json:"receiver_uuid"`
 	OfferHash    string `json:"offer_hash"`
 	TradeHash    string `json:"trade_hash"`
 	WithTrashed  bool   `json:"with_trashed"`
-	Offset       int
-	Limit        int
+	// every non-empty list matches any of its values
+	FeedbackTypes           []string `json:"feedback_type"`
+	OfferPaymentMethodSlugs []string `json:"offer_payment_method_slug"`
+	OfferFiatCodes          []string `json:"offer_fiat_code"`
+	OfferCryptoCodes        []string `json:"offer_crypto_code"`
+	OfferTypes              []string `json:"offer_type"`
+	TradeStatuses           []string `json:"trade_status"`
+	// CreatedFrom is inclusive and CreatedTo is exclusive, both are "2006-01-02 15:04:05"
+	CreatedFrom string `json:"created_from"`
+	CreatedTo   string `json:"created_to"`
+	// the amount bounds are inclusive decimals in USD
+	AmountMin string `json:"amount_min"`
+	AmountMax string `json:"amount_max"`
+	// Query is a full-text search over the feedback messages
+	Query  string `json:"q"`
+	Offset int
+	Limit  int
+	// Sort falls back to DefaultSort when nil
+	Sort *Sort
+	// Cursor switches Find to keyset pagination, Offset is ignored then
+	Cursor    *Cursor
+	SkipTotal bool
+}
+
+const (
+	SortCreatedAt    = "created_at"
+	SortUpdatedAt    = "updated_at"
+	SortAmount       = "amount"
+	SortFeedbackType = "feedback_type"
+	// SortRelevance is only valid together with a search query and can't be paginated by a cursor
+	SortRelevance = "relevance"
+)
+
+var DefaultSort = &Sort{Field: SortCreatedAt, Desc: true}
+
+// Sort orders feedbacks by one of the whitelisted fields, ties are broken by id in the same direction
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort accepts a field name optionally prefixed by "-" for the descending order
+func ParseSort(input string) (*Sort, error) {
+	sort := Sort{Field: input}
+	if strings.HasPrefix(input, "-") {
+		sort = Sort{Field: input[1:], Desc: true}
+	}
+
+	switch sort.Field {
+	case SortCreatedAt, SortUpdatedAt, SortAmount, SortFeedbackType, SortRelevance:
+		return &sort, nil
+	default:
+		return nil, fmt.Errorf("unknown sort field %q", sort.Field)
+	}
+}
+
+func (s *Sort) String() string {
+	if s.Desc {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// Nullable tells whether the sort field may be NULL, like the amount of the
+// feedbacks MySQL stored before it was required. NULL sorts as the lowest value.
+func (s *Sort) Nullable() bool {
+	return s.Field == SortAmount
+}
+
+// Value returns the feedback's value of the sort field as it is stored in a
+// cursor, an empty one stands for NULL
+func (s *Sort) Value(feedback *Feedback) string {
+	switch s.Field {
+	case SortUpdatedAt:
+		return feedback.UpdatedAt
+	case SortAmount:
+		return feedback.TradeFiatAmountRequestedInUsd
+	case SortFeedbackType:
+		return feedback.FeedbackType
+	default:
+		return feedback.CreatedAt
+	}
+}
+
+// MatchesQuery is the substring fallback of the full-text search for backends without one
+func MatchesQuery(feedback *Feedback, query string) bool {
+	return Relevance(feedback, query) > 0
+}
+
+// Relevance scores a feedback by the number of query words found in its message
+func Relevance(feedback *Feedback, query string) int {
+	message := strings.ToLower(feedback.Message)
+
+	score := 0
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		score += strings.Count(message, word)
+	}
+
+	return score
+}
+
+// Cursor points at the last item of a page in the (sort field, id) order.
+// Null marks the last item without a value of a Nullable sort field.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Null  bool   `json:"n,omitempty"`
+	ID    int    `json:"id"`
+}
+
+func NewCursor(sort *Sort, feedback *Feedback) *Cursor {
+	value := sort.Value(feedback)
+	return &Cursor{
+		Sort:  sort.String(),
+		Value: value,
+		Null:  value == "" && sort.Nullable(),
+		ID:    feedback.ID,
+	}
+}
+
+func (c *Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func DecodeCursor(input string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(raw, &cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if cursor.ID < 1 || (cursor.Value == "") != cursor.Null {
+		return nil, errors.New("invalid cursor")
+	}
+
+	// cursors issued before sorting was introduced always follow the default order
+	if cursor.Sort == "" {
+		cursor.Sort = DefaultSort.String()
+	}
+
+	return &cursor, nil
+}
+
+type Stats struct {
+	UserUuid           string  `json:"user_uuid"`
+	Positive           int     `json:"positive"`
+	Negative           int     `json:"negative"`
+	Initial            int     `json:"initial"`
+	Total              int     `json:"total"`
+	PositivePercentage float64 `json:"positive_percentage"`
+}
+
+// NewStats fills in the totals derived from the stored counters
+func NewStats(userUuid string, positive, negative, initial int) *Stats {
+	stats := Stats{
+		UserUuid: userUuid,
+		Positive: positive,
+		Negative: negative,
+		Initial:  initial,
+		Total:    positive + negative,
+	}
+
+	if stats.Total > 0 {
+		stats.PositivePercentage = math.Round(float64(positive)*10000/float64(stats.Total)) / 100
+	}
+
+	return &stats
+}
+
+// DefaultStatsBatchSize is the number of users RebuildStats fixes per transaction
+const DefaultStatsBatchSize = 500
+
+type RebuildStatsRequest struct {
+	// DryRun only reports the drifted counters
+	DryRun    bool
+	BatchSize int
+}
+
+// StatsCounts are the counters RebuildStats recomputes from the feedbacks
+type StatsCounts struct {
+	Positive int `json:"positive"`
+	Negative int `json:"negative"`
+}
+
+// StatsDiff is a user whose stored counters don't match the feedbacks
+type StatsDiff struct {
+	UserUuid string      `json:"user_uuid"`
+	Stored   StatsCounts `json:"stored"`
+	Expected StatsCounts `json:"expected"`
+}
+
+// NewStatsDiffs compares the counters of the users, missing ones count as zero
+func NewStatsDiffs(users []string, stored, expected map[string]StatsCounts) []*StatsDiff {
+	diffs := make([]*StatsDiff, 0)
+	for _, userUuid := range users {
+		if stored[userUuid] != expected[userUuid] {
+			diffs = append(diffs, &StatsDiff{UserUuid: userUuid, Stored: stored[userUuid], Expected: expected[userUuid]})
+		}
+	}
+
+	return diffs
 }
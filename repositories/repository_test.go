@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	sort := &Sort{Field: SortAmount, Desc: true}
+	cursor := NewCursor(sort, &Feedback{ID: 42, TradeFiatAmountRequestedInUsd: "320.12"})
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("DecodeCursor failed: %v", err)
+	}
+	if *decoded != *cursor {
+		t.Errorf("expected %+v, got %+v", cursor, decoded)
+	}
+	if decoded.Sort != "-amount" {
+		t.Errorf("expected the cursor to remember the -amount sort, got %q", decoded.Sort)
+	}
+}
+
+func TestNullCursorRoundTrip(t *testing.T) {
+	cursor := NewCursor(&Sort{Field: SortAmount}, &Feedback{ID: 42})
+	if !cursor.Null {
+		t.Fatalf("expected a missing amount to make a NULL cursor, got %+v", cursor)
+	}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("DecodeCursor failed: %v", err)
+	}
+	if *decoded != *cursor {
+		t.Errorf("expected %+v, got %+v", cursor, decoded)
+	}
+}
+
+func TestDecodeLegacyCursor(t *testing.T) {
+	// cursors issued before sorting carry no sort and follow the default order
+	input := base64.RawURLEncoding.EncodeToString([]byte(`{"v":"2021-09-06 05:01:43","id":42}`))
+
+	cursor, err := DecodeCursor(input)
+	if err != nil {
+		t.Fatalf("DecodeCursor failed: %v", err)
+	}
+	if cursor.Sort != DefaultSort.String() || cursor.Value != "2021-09-06 05:01:43" || cursor.ID != 42 {
+		t.Errorf("expected a default sort cursor, got %+v", cursor)
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	for _, input := range []string{
+		"garbage!",
+		base64.RawURLEncoding.EncodeToString([]byte(`not json`)),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"v":"2021-09-06 05:01:43"}`)),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"v":"","id":42}`)),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"v":"320.12","n":true,"id":42}`)),
+	} {
+		if cursor, err := DecodeCursor(input); err == nil {
+			t.Errorf("expected %q to be rejected, got %+v", input, cursor)
+		}
+	}
+}
+
+func TestRelevance(t *testing.T) {
+	feedback := &Feedback{Message: "Scam! A scam, slow trade"}
+
+	for query, expected := range map[string]int{
+		"scam":       2,
+		"SCAM trade": 3,
+		"refund":     0,
+		"  ":         0,
+	} {
+		if actual := Relevance(feedback, query); actual != expected {
+			t.Errorf("expected %q to score %d, got %d", query, expected, actual)
+		}
+	}
+	if MatchesQuery(feedback, "refund") || !MatchesQuery(feedback, "slow") {
+		t.Errorf("expected only the words of the message to match")
+	}
+}
@@ -0,0 +1,795 @@
+// Package repositorytest is the behaviour every repository.Repository
+// implementation must share. A backend runs it from its own tests:
+//
+//	func TestRepositorySuite(t *testing.T) {
+//		repositorytest.RunSuite(t, newTestRepository)
+//	}
+package repositorytest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	repository "feedback-service-go/repositories"
+)
+
+const (
+	senderUuid   = "807a51d6-a81b-4b66-9596-5b17ea26b136"
+	receiverUuid = "807a51d6-a81b-4b66-9596-5b17ea26b137"
+	ownerUuid    = "807a51d6-a81b-4b66-9596-5b17ea26b138"
+)
+
+// RunSuite runs every scenario against a fresh repository. newRepository must
+// return an empty repository and may skip the test when the backend is unavailable.
+func RunSuite(t *testing.T, newRepository func(t *testing.T) repository.Repository) {
+	for _, scenario := range []struct {
+		name string
+		run  func(t *testing.T, repo repository.Repository)
+	}{
+		{"CreateAndFind", testCreateAndFind},
+		{"Update", testUpdate},
+		{"StatsFollowTypeChanges", testStatsFollowTypeChanges},
+		{"UpdateSkipsTrashed", testUpdateSkipsTrashed},
+		{"DeleteOffer", testDeleteOffer},
+		{"ChangeTradeStatus", testChangeTradeStatus},
+		{"Trashed", testTrashed},
+		{"Pagination", testPagination},
+		{"PaginationAcrossMissingAmounts", testPaginationAcrossMissingAmounts},
+		{"Concurrency", testConcurrency},
+		{"SameReceiver", testSameReceiver},
+		{"RebuildStats", testRebuildStats},
+	} {
+		scenario := scenario
+		t.Run(scenario.name, func(t *testing.T) {
+			scenario.run(t, newRepository(t))
+		})
+	}
+}
+
+// NewCreateRequest returns a valid request, the suite tweaks its copies per scenario
+func NewCreateRequest(message string) *repository.CreateRequest {
+	return &repository.CreateRequest{
+		SenderUuid:                    senderUuid,
+		SenderName:                    "sender#1",
+		SenderAvatar:                  "sender#1 avatar",
+		ReceiverUuid:                  receiverUuid,
+		ReceiverName:                  "receiver#1",
+		ReceiverAvatar:                "receiver#1 avatar",
+		OfferHash:                     "ksO3jso7aDi",
+		OfferAthorized:                true,
+		OfferOwnerUuid:                ownerUuid,
+		OfferType:                     "SELL",
+		OfferPaymentMethod:            "PayPal",
+		OfferPaymentMethodSlug:        "paypal_slug",
+		OfferFiatCode:                 "RUB",
+		OfferCryptoCode:               "BTC",
+		TradeHash:                     "isO9AlIU8s2",
+		TradeFiatAmountRequestedInUsd: "320.12",
+		TradeStatus:                   "RELEASED",
+		Message:                       message,
+		FeedbackType:                  "POSITIVE",
+		CreatedAt:                     "2021-09-06 05:01:43",
+	}
+}
+
+var ctx = context.Background()
+
+func create(t *testing.T, repo repository.Repository, request *repository.CreateRequest) int {
+	t.Helper()
+
+	id, err := repo.Create(ctx, request)
+	if err != nil {
+		t.Fatalf("Create(%q) failed: %v", request.Message, err)
+	}
+
+	return id
+}
+
+func findByID(t *testing.T, repo repository.Repository, id int) *repository.Feedback {
+	t.Helper()
+
+	feedback, err := repo.FindByID(ctx, id)
+	if err != nil {
+		t.Fatalf("FindByID(%d) failed: %v", id, err)
+	}
+
+	return feedback
+}
+
+func find(t *testing.T, repo repository.Repository, filter *repository.RequestFilter) *repository.FeedbackResponse {
+	t.Helper()
+
+	response, err := repo.Find(ctx, filter)
+	if err != nil {
+		t.Fatalf("Find(%+v) failed: %v", filter, err)
+	}
+
+	return response
+}
+
+func assertStats(t *testing.T, repo repository.Repository, userUuid string, positive, negative int) {
+	t.Helper()
+
+	stats, err := repo.GetStats(ctx, userUuid)
+	if err != nil {
+		t.Fatalf("GetStats(%s) failed: %v", userUuid, err)
+	}
+	if stats.Positive != positive || stats.Negative != negative || stats.Total != positive+negative {
+		t.Errorf("expected %d positive and %d negative feedbacks for %s, got %+v", positive, negative, userUuid, stats)
+	}
+}
+
+func assertNoRows(t *testing.T, err error, call string) {
+	t.Helper()
+
+	if err != sql.ErrNoRows {
+		t.Errorf("%s expected sql.ErrNoRows, got %v", call, err)
+	}
+}
+
+func assertUpdatedAfter(t *testing.T, repo repository.Repository, id int, updatedAt string) {
+	t.Helper()
+
+	// the layout of the timestamps keeps them ordered as strings
+	if feedback := findByID(t, repo, id); feedback.UpdatedAt <= updatedAt {
+		t.Errorf("expected feedback %d to be updated after %s, got %s", id, updatedAt, feedback.UpdatedAt)
+	}
+}
+
+func assertUpdatedAt(t *testing.T, repo repository.Repository, id int, updatedAt string) {
+	t.Helper()
+
+	if feedback := findByID(t, repo, id); feedback.UpdatedAt != updatedAt {
+		t.Errorf("expected feedback %d to stay updated at %s, got %s", id, updatedAt, feedback.UpdatedAt)
+	}
+}
+
+// walk follows the cursors from the first page to the last one and returns the ids met
+func walk(t *testing.T, repo repository.Repository, filter *repository.RequestFilter) []int {
+	t.Helper()
+
+	var err error
+	walked := make([]int, 0)
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatalf("the %s cursor doesn't stop", filter.Sort)
+		}
+
+		response := find(t, repo, filter)
+		walked = append(walked, ids(response.Items)...)
+		if response.NextCursor == "" {
+			return walked
+		}
+
+		filter.Cursor, err = repository.DecodeCursor(response.NextCursor)
+		if err != nil {
+			t.Fatalf("DecodeCursor(%s) failed: %v", response.NextCursor, err)
+		}
+	}
+}
+
+func ids(feedbacks []*repository.Feedback) []int {
+	list := make([]int, 0, len(feedbacks))
+	for _, feedback := range feedbacks {
+		list = append(list, feedback.ID)
+	}
+	return list
+}
+
+func testCreateAndFind(t *testing.T, repo repository.Repository) {
+	request := NewCreateRequest("O'Reilly was great")
+	id := create(t, repo, request)
+
+	feedback := findByID(t, repo, id)
+	if feedback.ID != id ||
+		feedback.ParentId.Valid ||
+		feedback.SenderUuid != request.SenderUuid ||
+		feedback.SenderName != request.SenderName ||
+		feedback.SenderAvatar != request.SenderAvatar ||
+		feedback.ReceiverUuid != request.ReceiverUuid ||
+		feedback.ReceiverName != request.ReceiverName ||
+		feedback.ReceiverAvatar != request.ReceiverAvatar ||
+		feedback.OfferHash != request.OfferHash ||
+		feedback.OfferAthorized != request.OfferAthorized ||
+		feedback.OfferOwnerUuid != request.OfferOwnerUuid ||
+		feedback.OfferType != request.OfferType ||
+		feedback.OfferPaymentMethod != request.OfferPaymentMethod ||
+		feedback.OfferPaymentMethodSlug != request.OfferPaymentMethodSlug ||
+		feedback.OfferFiatCode != request.OfferFiatCode ||
+		feedback.OfferCryptoCode != request.OfferCryptoCode ||
+		feedback.OfferDeletedAt.Valid ||
+		feedback.TradeHash != request.TradeHash ||
+		feedback.TradeFiatAmountRequestedInUsd != request.TradeFiatAmountRequestedInUsd ||
+		feedback.TradeStatus != request.TradeStatus ||
+		feedback.Message != request.Message ||
+		feedback.FeedbackType != request.FeedbackType ||
+		feedback.CreatedAt != request.CreatedAt ||
+		feedback.UpdatedAt == "" ||
+		feedback.DeletedAt.Valid {
+		t.Errorf("expected the feedback to match %+v, got %+v", request, feedback)
+	}
+
+	reply := NewCreateRequest("reply")
+	reply.ParentId = id
+	reply.SenderUuid, reply.ReceiverUuid = request.ReceiverUuid, request.SenderUuid
+	reply.FeedbackType = "NEGATIVE"
+	replyId := create(t, repo, reply)
+
+	feedback = findByID(t, repo, replyId)
+	if !feedback.ParentId.Valid || int(feedback.ParentId.Int64) != id {
+		t.Errorf("expected parent_id %d, got %+v", id, feedback.ParentId)
+	}
+
+	_, err := repo.FindByID(ctx, replyId+1000)
+	assertNoRows(t, err, "FindByID of a missing feedback")
+
+	for _, filter := range []struct {
+		filter   repository.RequestFilter
+		expected []int
+	}{
+		{repository.RequestFilter{}, []int{replyId, id}},
+		{repository.RequestFilter{ParentId: id}, []int{replyId}},
+		{repository.RequestFilter{SenderUuid: senderUuid}, []int{id}},
+		{repository.RequestFilter{ReceiverUuid: senderUuid}, []int{replyId}},
+		{repository.RequestFilter{FeedbackTypes: []string{"NEGATIVE"}}, []int{replyId}},
+		{repository.RequestFilter{FeedbackTypes: []string{"POSITIVE", "NEGATIVE"}}, []int{replyId, id}},
+		{repository.RequestFilter{OfferFiatCodes: []string{"EUR"}}, []int{}},
+		{repository.RequestFilter{CreatedFrom: "2021-09-06 05:01:43", CreatedTo: "2021-09-06 05:01:44"}, []int{replyId, id}},
+		{repository.RequestFilter{CreatedTo: "2021-09-06 05:01:43"}, []int{}},
+		{repository.RequestFilter{AmountMin: "320.12", AmountMax: "320.12"}, []int{replyId, id}},
+		{repository.RequestFilter{AmountMin: "320.13"}, []int{}},
+	} {
+		filter.filter.Limit = 10
+		response := find(t, repo, &filter.filter)
+		if fmt.Sprint(ids(response.Items)) != fmt.Sprint(filter.expected) {
+			t.Errorf("Find(%+v) expected %v, got %v", filter.filter, filter.expected, ids(response.Items))
+		}
+		if response.Total == nil || *response.Total != len(filter.expected) {
+			t.Errorf("Find(%+v) expected the total of %d, got %v", filter.filter, len(filter.expected), response.Total)
+		}
+	}
+
+	assertStats(t, repo, receiverUuid, 1, 0)
+	assertStats(t, repo, senderUuid, 0, 1)
+	assertStats(t, repo, ownerUuid, 0, 0)
+}
+
+func testUpdate(t *testing.T, repo repository.Repository) {
+	id := create(t, repo, NewCreateRequest("message"))
+	otherRequest := NewCreateRequest("other message")
+	otherRequest.OfferFiatCode = "EUR"
+	otherId := create(t, repo, otherRequest)
+
+	err := repo.UpdateByID(ctx, id, &repository.PatchRequest{Message: "edited"})
+	if err != nil {
+		t.Fatalf("UpdateByID failed: %v", err)
+	}
+	feedback := findByID(t, repo, id)
+	if feedback.Message != "edited" || feedback.FeedbackType != "POSITIVE" {
+		t.Errorf("expected only the message to change, got %+v", feedback)
+	}
+
+	err = repo.Update(ctx, &repository.UpdateRequest{
+		SenderUuid:             senderUuid,
+		ReceiverUuid:           receiverUuid,
+		OfferPaymentMethodSlug: "paypal_slug",
+		OfferFiatCode:          "EUR",
+		FeedbackType:           "NEGATIVE",
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	feedback = findByID(t, repo, otherId)
+	if feedback.Message != "other message" || feedback.FeedbackType != "NEGATIVE" {
+		t.Errorf("expected only the feedback type to change, got %+v", feedback)
+	}
+	if feedback := findByID(t, repo, id); feedback.FeedbackType != "POSITIVE" {
+		t.Errorf("expected the feedback with another fiat code to stay positive, got %+v", feedback)
+	}
+
+	err = repo.Update(ctx, &repository.UpdateRequest{
+		SenderUuid:             senderUuid,
+		ReceiverUuid:           receiverUuid,
+		OfferPaymentMethodSlug: "sepa_slug",
+		OfferFiatCode:          "EUR",
+		Message:                "never stored",
+	})
+	assertNoRows(t, err, "Update of a missing feedback")
+
+	err = repo.UpdateByID(ctx, otherId+1000, &repository.PatchRequest{Message: "never stored"})
+	assertNoRows(t, err, "UpdateByID of a missing feedback")
+}
+
+func testUpdateSkipsTrashed(t *testing.T, repo repository.Repository) {
+	trashed := create(t, repo, NewCreateRequest("trashed"))
+	err := repo.Delete(ctx, trashed)
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	assertStats(t, repo, receiverUuid, 0, 0)
+
+	update := &repository.UpdateRequest{
+		SenderUuid:             senderUuid,
+		ReceiverUuid:           receiverUuid,
+		OfferPaymentMethodSlug: "paypal_slug",
+		OfferFiatCode:          "RUB",
+		FeedbackType:           "NEGATIVE",
+	}
+	err = repo.Update(ctx, update)
+	assertNoRows(t, err, "Update of a trashed feedback")
+	assertStats(t, repo, receiverUuid, 0, 0)
+
+	// a live feedback of the same offer is updated instead of the older trashed one
+	live := create(t, repo, NewCreateRequest("live"))
+	err = repo.Update(ctx, update)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if feedback := findByID(t, repo, live); feedback.FeedbackType != "NEGATIVE" {
+		t.Errorf("expected the live feedback to become negative, got %+v", feedback)
+	}
+	assertStats(t, repo, receiverUuid, 0, 1)
+
+	err = repo.Restore(ctx, trashed)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	assertStats(t, repo, receiverUuid, 1, 1)
+}
+
+func testStatsFollowTypeChanges(t *testing.T, repo repository.Repository) {
+	first := create(t, repo, NewCreateRequest("first"))
+	second := create(t, repo, NewCreateRequest("second"))
+	negative := NewCreateRequest("third")
+	negative.FeedbackType = "NEGATIVE"
+	create(t, repo, negative)
+	assertStats(t, repo, receiverUuid, 2, 1)
+
+	err := repo.UpdateByID(ctx, first, &repository.PatchRequest{FeedbackType: "NEGATIVE"})
+	if err != nil {
+		t.Fatalf("UpdateByID failed: %v", err)
+	}
+	assertStats(t, repo, receiverUuid, 1, 2)
+
+	// the same type again must not be counted twice
+	err = repo.UpdateByID(ctx, first, &repository.PatchRequest{FeedbackType: "NEGATIVE"})
+	if err != nil {
+		t.Fatalf("UpdateByID failed: %v", err)
+	}
+	assertStats(t, repo, receiverUuid, 1, 2)
+
+	err = repo.Delete(ctx, second)
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	assertStats(t, repo, receiverUuid, 0, 2)
+
+	err = repo.Restore(ctx, second)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	assertStats(t, repo, receiverUuid, 1, 2)
+
+	stats, err := repo.GetStats(ctx, receiverUuid)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if stats.UserUuid != receiverUuid || stats.PositivePercentage != 33.33 {
+		t.Errorf("expected 33.33%% positive feedbacks for %s, got %+v", receiverUuid, stats)
+	}
+}
+
+func testDeleteOffer(t *testing.T, repo repository.Repository) {
+	first := create(t, repo, NewCreateRequest("first"))
+	second := create(t, repo, NewCreateRequest("second"))
+	otherRequest := NewCreateRequest("other")
+	otherRequest.OfferHash = "A3O3jso7aUi"
+	other := create(t, repo, otherRequest)
+	createdAt := findByID(t, repo, first).UpdatedAt
+	otherCreatedAt := findByID(t, repo, other).UpdatedAt
+	// updated_at is stored with a second precision
+	time.Sleep(time.Second)
+
+	err := repo.DeleteOffer(ctx, &repository.DeleteOfferRequest{OfferHash: "ksO3jso7aDi", DeletedAt: "2021-09-07 10:00:00"})
+	if err != nil {
+		t.Fatalf("DeleteOffer failed: %v", err)
+	}
+	assertUpdatedAfter(t, repo, first, createdAt)
+	assertUpdatedAt(t, repo, other, otherCreatedAt)
+
+	for _, id := range []int{first, second} {
+		feedback := findByID(t, repo, id)
+		if !feedback.OfferDeletedAt.Valid || feedback.OfferDeletedAt.String != "2021-09-07 10:00:00" {
+			t.Errorf("expected feedback %d to have the offer deleted at 2021-09-07 10:00:00, got %+v", id, feedback.OfferDeletedAt)
+		}
+	}
+	if feedback := findByID(t, repo, other); feedback.OfferDeletedAt.Valid {
+		t.Errorf("expected the other offer to stay, got %+v", feedback.OfferDeletedAt)
+	}
+
+	// the feedbacks themselves are still listed and counted
+	response := find(t, repo, &repository.RequestFilter{Limit: 10})
+	if len(response.Items) != 3 {
+		t.Errorf("expected 3 feedbacks, got %v", ids(response.Items))
+	}
+	assertStats(t, repo, receiverUuid, 3, 0)
+
+	err = repo.DeleteOffer(ctx, &repository.DeleteOfferRequest{OfferHash: "nOneXisting", DeletedAt: "2021-09-07 10:00:00"})
+	if err != nil {
+		t.Errorf("DeleteOffer of a missing offer expected to succeed, got %v", err)
+	}
+}
+
+func testChangeTradeStatus(t *testing.T, repo repository.Repository) {
+	id := create(t, repo, NewCreateRequest("first"))
+	otherRequest := NewCreateRequest("other")
+	otherRequest.TradeHash = "tsO9Al83k8s"
+	other := create(t, repo, otherRequest)
+	createdAt := findByID(t, repo, id).UpdatedAt
+	otherCreatedAt := findByID(t, repo, other).UpdatedAt
+	// updated_at is stored with a second precision
+	time.Sleep(time.Second)
+
+	err := repo.ChangeTradeStatus(ctx, &repository.ChangeTradeStatusRequest{TradeHash: "isO9AlIU8s2", TradeStatus: "DISPUTED"})
+	if err != nil {
+		t.Fatalf("ChangeTradeStatus failed: %v", err)
+	}
+	assertUpdatedAfter(t, repo, id, createdAt)
+	assertUpdatedAt(t, repo, other, otherCreatedAt)
+
+	if feedback := findByID(t, repo, id); feedback.TradeStatus != "DISPUTED" {
+		t.Errorf("expected the DISPUTED trade status, got %s", feedback.TradeStatus)
+	}
+	if feedback := findByID(t, repo, other); feedback.TradeStatus != "RELEASED" {
+		t.Errorf("expected the other trade to stay RELEASED, got %s", feedback.TradeStatus)
+	}
+
+	response := find(t, repo, &repository.RequestFilter{TradeStatuses: []string{"DISPUTED"}, Limit: 10})
+	if fmt.Sprint(ids(response.Items)) != fmt.Sprint([]int{id}) {
+		t.Errorf("expected only feedback %d to be DISPUTED, got %v", id, ids(response.Items))
+	}
+}
+
+func testTrashed(t *testing.T, repo repository.Repository) {
+	root := create(t, repo, NewCreateRequest("root"))
+	reply := NewCreateRequest("reply")
+	reply.ParentId = root
+	reply.CreatedAt = "2021-09-06 05:01:44"
+	replyId := create(t, repo, reply)
+
+	err := repo.Delete(ctx, replyId)
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	_, err = repo.FindByID(ctx, replyId)
+	assertNoRows(t, err, "FindByID of a deleted feedback")
+	err = repo.Delete(ctx, replyId)
+	assertNoRows(t, err, "Delete of a deleted feedback")
+	err = repo.UpdateByID(ctx, replyId, &repository.PatchRequest{Message: "never stored"})
+	assertNoRows(t, err, "UpdateByID of a deleted feedback")
+	err = repo.Restore(ctx, root)
+	assertNoRows(t, err, "Restore of a feedback that isn't deleted")
+
+	response := find(t, repo, &repository.RequestFilter{Limit: 10})
+	if fmt.Sprint(ids(response.Items)) != fmt.Sprint([]int{root}) || *response.Total != 1 {
+		t.Errorf("expected only feedback %d, got %v", root, ids(response.Items))
+	}
+
+	response = find(t, repo, &repository.RequestFilter{WithTrashed: true, Limit: 10})
+	if fmt.Sprint(ids(response.Items)) != fmt.Sprint([]int{replyId, root}) || *response.Total != 2 {
+		t.Errorf("expected feedbacks %d and %d, got %v", replyId, root, ids(response.Items))
+	}
+	if !response.Items[0].DeletedAt.Valid {
+		t.Errorf("expected feedback %d to have deleted_at, got %+v", replyId, response.Items[0])
+	}
+
+	thread, err := repo.FindThread(ctx, root, 3, false)
+	if err != nil {
+		t.Fatalf("FindThread failed: %v", err)
+	}
+	if len(thread.Replies) != 0 {
+		t.Errorf("expected no replies, got %d", len(thread.Replies))
+	}
+
+	thread, err = repo.FindThread(ctx, root, 3, true)
+	if err != nil {
+		t.Fatalf("FindThread failed: %v", err)
+	}
+	if len(thread.Replies) != 1 || thread.Replies[0].ID != replyId {
+		t.Errorf("expected the deleted reply %d, got %+v", replyId, thread.Replies)
+	}
+
+	_, err = repo.FindThread(ctx, replyId, 3, false)
+	assertNoRows(t, err, "FindThread of a deleted feedback")
+
+	err = repo.Restore(ctx, replyId)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if feedback := findByID(t, repo, replyId); feedback.DeletedAt.Valid {
+		t.Errorf("expected the restored feedback to have no deleted_at, got %+v", feedback.DeletedAt)
+	}
+}
+
+func testPagination(t *testing.T, repo repository.Repository) {
+	created := make([]int, 0)
+	for i, amount := range []string{"30.00", "10.00", "50.00", "20.00", "10.00", "40.00", "30.00"} {
+		request := NewCreateRequest(fmt.Sprintf("feedback#%d", i))
+		request.CreatedAt = fmt.Sprintf("2021-09-06 05:01:%02d", i)
+		request.TradeFiatAmountRequestedInUsd = amount
+		created = append(created, create(t, repo, request))
+	}
+
+	// the newest first by default
+	expected := make([]int, 0, len(created))
+	for i := len(created) - 1; i >= 0; i-- {
+		expected = append(expected, created[i])
+	}
+
+	response := find(t, repo, &repository.RequestFilter{Offset: 2, Limit: 3})
+	if fmt.Sprint(ids(response.Items)) != fmt.Sprint(expected[2:5]) {
+		t.Errorf("expected the page %v, got %v", expected[2:5], ids(response.Items))
+	}
+	if *response.Total != len(created) || response.Offser != 2 || response.Limit != 3 {
+		t.Errorf("expected the total of %d, offset 2 and limit 3, got %+v", len(created), response)
+	}
+
+	response = find(t, repo, &repository.RequestFilter{Offset: len(created), Limit: 3})
+	if len(response.Items) != 0 || response.NextCursor != "" {
+		t.Errorf("expected an empty last page, got %+v", response)
+	}
+
+	response = find(t, repo, &repository.RequestFilter{Limit: 3, SkipTotal: true})
+	if response.Total != nil {
+		t.Errorf("expected no total, got %d", *response.Total)
+	}
+
+	byAmount := []int{created[1], created[4], created[3], created[0], created[6], created[5], created[2]}
+	for _, order := range []struct {
+		sort     *repository.Sort
+		expected []int
+	}{
+		{repository.DefaultSort, expected},
+		{&repository.Sort{Field: repository.SortCreatedAt}, created},
+		{&repository.Sort{Field: repository.SortAmount}, byAmount},
+	} {
+		walked := walk(t, repo, &repository.RequestFilter{Limit: 2, Sort: order.sort})
+		if fmt.Sprint(walked) != fmt.Sprint(order.expected) {
+			t.Errorf("expected the %s cursor to walk %v, got %v", order.sort, order.expected, walked)
+		}
+	}
+}
+
+func testPaginationAcrossMissingAmounts(t *testing.T, repo repository.Repository) {
+	// the feedbacks MySQL stored before the amount was required have none
+	created := make([]int, 0)
+	for i, amount := range []string{"30.00", "", "10.00", "", "20.00"} {
+		request := NewCreateRequest(fmt.Sprintf("feedback#%d", i))
+		request.TradeFiatAmountRequestedInUsd = amount
+		id, err := repo.Create(ctx, request)
+		if err != nil && amount == "" && len(created) == 1 {
+			t.Skipf("the schema requires the amount: %v", err)
+		}
+		if err != nil {
+			t.Fatalf("Create(%q) failed: %v", request.Message, err)
+		}
+		created = append(created, id)
+	}
+
+	if feedback := findByID(t, repo, created[1]); feedback.TradeFiatAmountRequestedInUsd != "" {
+		t.Errorf("expected no amount, got %q", feedback.TradeFiatAmountRequestedInUsd)
+	}
+
+	// a missing amount is the lowest one
+	ascending := []int{created[1], created[3], created[2], created[4], created[0]}
+	descending := []int{created[0], created[4], created[2], created[3], created[1]}
+	for _, order := range []struct {
+		sort     *repository.Sort
+		expected []int
+	}{
+		{&repository.Sort{Field: repository.SortAmount}, ascending},
+		{&repository.Sort{Field: repository.SortAmount, Desc: true}, descending},
+	} {
+		response := find(t, repo, &repository.RequestFilter{Limit: 10, Sort: order.sort})
+		if fmt.Sprint(ids(response.Items)) != fmt.Sprint(order.expected) {
+			t.Errorf("expected the %s order %v, got %v", order.sort, order.expected, ids(response.Items))
+		}
+
+		for _, limit := range []int{1, 2} {
+			walked := walk(t, repo, &repository.RequestFilter{Limit: limit, Sort: order.sort})
+			if fmt.Sprint(walked) != fmt.Sprint(order.expected) {
+				t.Errorf("expected the %s cursor by %d to walk %v, got %v", order.sort, limit, order.expected, walked)
+			}
+		}
+	}
+
+	response := find(t, repo, &repository.RequestFilter{AmountMin: "0", Limit: 10})
+	if len(response.Items) != 3 {
+		t.Errorf("expected the amount bounds to skip the missing amounts, got %v", ids(response.Items))
+	}
+}
+
+func testConcurrency(t *testing.T, repo repository.Repository) {
+	const (
+		receivers = 4
+		perWorker = 10
+	)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, receivers*perWorker*2)
+	for worker := 0; worker < receivers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			for i := 0; i < perWorker; i++ {
+				request := NewCreateRequest(fmt.Sprintf("worker#%d feedback#%d", worker, i))
+				request.ReceiverUuid = fmt.Sprintf("807a51d6-a81b-4b66-9596-5b17ea26c%03d", worker)
+				if i%2 == 1 {
+					request.FeedbackType = "NEGATIVE"
+				}
+				if _, err := repo.Create(ctx, request); err != nil {
+					errs <- err
+				}
+
+				if _, err := repo.Find(ctx, &repository.RequestFilter{ReceiverUuid: request.ReceiverUuid, Limit: 5}); err != nil {
+					errs <- err
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent call failed: %v", err)
+	}
+
+	response := find(t, repo, &repository.RequestFilter{Limit: 1})
+	if *response.Total != receivers*perWorker {
+		t.Errorf("expected %d feedbacks, got %d", receivers*perWorker, *response.Total)
+	}
+	for worker := 0; worker < receivers; worker++ {
+		assertStats(t, repo, fmt.Sprintf("807a51d6-a81b-4b66-9596-5b17ea26c%03d", worker), perWorker/2, perWorker/2)
+	}
+}
+
+// testSameReceiver races creates, edits and deletes of one receiver starting
+// without its stats row, the counters must match the feedbacks left
+func testSameReceiver(t *testing.T, repo repository.Repository) {
+	const (
+		workers   = 8
+		perWorker = 5
+	)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker*3)
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			for i := 0; i < perWorker; i++ {
+				request := NewCreateRequest(fmt.Sprintf("worker#%d feedback#%d", worker, i))
+				request.SenderUuid = fmt.Sprintf("807a51d6-a81b-4b66-9596-5b17ea26d%03d", worker)
+				request.OfferPaymentMethodSlug = fmt.Sprintf("slug#%d", i)
+				id, err := repo.Create(ctx, request)
+				if err != nil {
+					errs <- err
+					continue
+				}
+
+				switch i % 3 {
+				case 0:
+					err = repo.UpdateByID(ctx, id, &repository.PatchRequest{FeedbackType: "NEGATIVE"})
+				case 1:
+					err = repo.Update(ctx, &repository.UpdateRequest{
+						SenderUuid:             request.SenderUuid,
+						ReceiverUuid:           request.ReceiverUuid,
+						OfferPaymentMethodSlug: request.OfferPaymentMethodSlug,
+						OfferFiatCode:          request.OfferFiatCode,
+						FeedbackType:           "NEGATIVE",
+					})
+				default:
+					err = repo.Delete(ctx, id)
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent call failed: %v", err)
+	}
+
+	// every third feedback is deleted, the rest are turned negative
+	left := workers * (perWorker - perWorker/3)
+	response := find(t, repo, &repository.RequestFilter{ReceiverUuid: receiverUuid, Limit: 1})
+	if *response.Total != left {
+		t.Errorf("expected %d feedbacks of %s, got %d", left, receiverUuid, *response.Total)
+	}
+	assertStats(t, repo, receiverUuid, 0, left)
+}
+
+// testRebuildStats walks the users in batches smaller than their number. The
+// counters are corrupted through the database of the SQL backends only.
+func testRebuildStats(t *testing.T, repo repository.Repository) {
+	const receivers = 5
+
+	users := make([]string, 0, receivers)
+	for i := 0; i < receivers; i++ {
+		request := NewCreateRequest(fmt.Sprintf("feedback#%d", i))
+		request.ReceiverUuid = fmt.Sprintf("807a51d6-a81b-4b66-9596-5b17ea26e%03d", i)
+		users = append(users, request.ReceiverUuid)
+		id := create(t, repo, request)
+
+		request.FeedbackType = "NEGATIVE"
+		create(t, repo, request)
+
+		// the last receiver keeps the negative one only
+		if i == receivers-1 {
+			if err := repo.Delete(ctx, id); err != nil {
+				t.Fatalf("Delete(%d) failed: %v", id, err)
+			}
+		}
+	}
+
+	request := &repository.RebuildStatsRequest{DryRun: true, BatchSize: 2}
+	diffs, err := repo.RebuildStats(ctx, request)
+	if err != nil {
+		t.Fatalf("RebuildStats failed: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("expected the counters kept by the writes to be right, got %+v", diffs[0])
+	}
+
+	db := repo.GetDB()
+	if db == nil {
+		return
+	}
+	_, err = db.ExecContext(ctx, "UPDATE feedback_stats SET positive = positive + 5, negative = 0")
+	if err != nil {
+		t.Fatalf("can't corrupt the stats: %v", err)
+	}
+
+	diffs, err = repo.RebuildStats(ctx, request)
+	if err != nil {
+		t.Fatalf("RebuildStats failed: %v", err)
+	}
+	if len(diffs) != receivers {
+		t.Fatalf("expected %d drifted users, got %d", receivers, len(diffs))
+	}
+	for i, diff := range diffs {
+		expected := repository.StatsCounts{Positive: 1, Negative: 1}
+		if i == receivers-1 {
+			expected.Positive = 0
+		}
+		if diff.UserUuid != users[i] || diff.Expected != expected || diff.Stored != (repository.StatsCounts{Positive: expected.Positive + 5}) {
+			t.Errorf("unexpected diff #%d %+v", i, diff)
+		}
+	}
+	assertStats(t, repo, users[0], 6, 0)
+
+	request.DryRun = false
+	diffs, err = repo.RebuildStats(ctx, request)
+	if err != nil || len(diffs) != receivers {
+		t.Fatalf("expected %d users to be fixed, got %d, %v", receivers, len(diffs), err)
+	}
+	assertStats(t, repo, users[0], 1, 1)
+	assertStats(t, repo, users[receivers-1], 0, 1)
+
+	request.DryRun = true
+	diffs, err = repo.RebuildStats(ctx, request)
+	if err != nil || len(diffs) != 0 {
+		t.Errorf("expected nothing left to fix, got %d, %v", len(diffs), err)
+	}
+}
@@ -0,0 +1,834 @@
+package sqliterepository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	_ "modernc.org/sqlite"
+
+	repository "feedback-service-go/repositories"
+)
+
+// the amount is printed back as DECIMAL(10,2) because the NUMERIC affinity drops trailing zeros
+const feedbackColumns string = "id, parent_id, sender_uuid, sender_name, sender_avatar, receiver_uuid, receiver_name, receiver_avatar, offer_hash, offer_authorized, offer_owner_uuid, offer_type, offer_payment_method, offer_payment_method_slug, offer_fiat_code, offer_crypto_code, offer_deleted_at, trade_hash, printf('%.2f', trade_fiat_amount_requested_in_usd), trade_status, message, feedback_type, created_at, updated_at, deleted_at"
+
+// now is the local time in the layout the timestamps are stored in, like NOW() of MySQL
+const now string = "datetime('now', 'localtime')"
+
+// sortColumns whitelists the expressions Find may order by. The relevance
+// is built per query by relevance.
+var sortColumns = map[string]string{
+	repository.SortCreatedAt:    "created_at",
+	repository.SortUpdatedAt:    "updated_at",
+	repository.SortAmount:       "trade_fiat_amount_requested_in_usd",
+	repository.SortFeedbackType: "feedback_type",
+	repository.SortRelevance:    "",
+}
+
+// defaultQueryTimeout bounds every repository call unless the caller passes another one to Open
+const defaultQueryTimeout = 5 * time.Second
+
+type sqliteRepository struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (r *sqliteRepository) GetDB() *sql.DB {
+	return r.db
+}
+
+// Open creates or opens the database file at path, ":memory:" keeps it in
+// memory. The schema comes from the migrations. A zero queryTimeout leaves
+// the calls bounded by the caller's context only.
+func Open(path string, queryTimeout time.Duration) (repository.Repository, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	dbConnection, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite has a single writer, one connection serializes the transactions
+	// instead of failing them with SQLITE_BUSY, and keeps ":memory:" a single database
+	dbConnection.SetMaxOpenConns(1)
+
+	err = dbConnection.Ping()
+	if err != nil {
+		dbConnection.Close()
+		return nil, err
+	}
+
+	return &sqliteRepository{db: dbConnection, timeout: queryTimeout}, nil
+}
+
+func (r *sqliteRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+func (r *sqliteRepository) Close() {
+	r.db.Close()
+}
+
+// searchWords are the lowercased query words, the same ones repository.Relevance counts
+func searchWords(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
+
+// search matches the messages containing any of the words
+func search(words []string) (string, []interface{}) {
+	conditions := make([]string, 0, len(words))
+	args := make([]interface{}, 0, len(words))
+	for _, word := range words {
+		conditions = append(conditions, "instr(lower(message), ?) > 0")
+		args = append(args, word)
+	}
+
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// relevance counts the occurrences of the words in the message
+func relevance(words []string) (string, []interface{}) {
+	counts := make([]string, 0, len(words))
+	args := make([]interface{}, 0, len(words))
+	for _, word := range words {
+		counts = append(counts, "(length(lower(message)) - length(replace(lower(message), ?, ''))) / "+strconv.Itoa(utf8.RuneCountInString(word)))
+		args = append(args, word)
+	}
+
+	return "(" + strings.Join(counts, " + ") + ")", args
+}
+
+func (r *sqliteRepository) FindByID(ctx context.Context, id int) (*repository.Feedback, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	const queryTemplate string = "SELECT " + feedbackColumns + " FROM feedbacks WHERE id = ? AND deleted_at IS NULL"
+
+	return scanFeedback(r.db.QueryRowContext(ctx, queryTemplate, id))
+}
+
+func (r *sqliteRepository) Find(ctx context.Context, filter *repository.RequestFilter) (*repository.FeedbackResponse, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	feedbacks := make([]*repository.Feedback, 0)
+
+	where := "1=1"
+	args := make([]interface{}, 0)
+	if !filter.WithTrashed {
+		where += " AND deleted_at IS NULL"
+	}
+	if filter.ParentId > 0 {
+		where += " AND parent_id = ?"
+		args = append(args, filter.ParentId)
+	}
+	for _, equal := range []struct {
+		column string
+		value  string
+	}{
+		{"sender_uuid", filter.SenderUuid},
+		{"receiver_uuid", filter.ReceiverUuid},
+		{"offer_hash", filter.OfferHash},
+		{"trade_hash", filter.TradeHash},
+	} {
+		if equal.value != "" {
+			where += " AND " + equal.column + " = ?"
+			args = append(args, equal.value)
+		}
+	}
+	for _, in := range []struct {
+		column string
+		values []string
+	}{
+		{"feedback_type", filter.FeedbackTypes},
+		{"offer_payment_method_slug", filter.OfferPaymentMethodSlugs},
+		{"offer_fiat_code", filter.OfferFiatCodes},
+		{"offer_crypto_code", filter.OfferCryptoCodes},
+		{"offer_type", filter.OfferTypes},
+		{"trade_status", filter.TradeStatuses},
+	} {
+		if len(in.values) == 0 {
+			continue
+		}
+		where += " AND " + in.column + " IN (?" + strings.Repeat(", ?", len(in.values)-1) + ")"
+		for _, value := range in.values {
+			args = append(args, value)
+		}
+	}
+	if filter.CreatedFrom != "" {
+		where += " AND created_at >= ?"
+		args = append(args, filter.CreatedFrom)
+	}
+	if filter.CreatedTo != "" {
+		where += " AND created_at < ?"
+		args = append(args, filter.CreatedTo)
+	}
+	if filter.AmountMin != "" {
+		where += " AND trade_fiat_amount_requested_in_usd >= ?"
+		args = append(args, filter.AmountMin)
+	}
+	if filter.AmountMax != "" {
+		where += " AND trade_fiat_amount_requested_in_usd <= ?"
+		args = append(args, filter.AmountMax)
+	}
+	words := searchWords(filter.Query)
+	if len(words) > 0 {
+		condition, searchArgs := search(words)
+		where += " AND " + condition
+		args = append(args, searchArgs...)
+	}
+
+	response := repository.FeedbackResponse{
+		Offser: filter.Offset,
+		Limit:  filter.Limit,
+	}
+
+	if !filter.SkipTotal {
+		var cnt int
+		result := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM feedbacks WHERE "+where, args...)
+		err := result.Scan(&cnt)
+		if err != nil {
+			return nil, err
+		}
+		response.Total = &cnt
+	}
+
+	sort := filter.Sort
+	if sort == nil {
+		sort = repository.DefaultSort
+	}
+	sortColumn, ok := sortColumns[sort.Field]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field %q", sort.Field)
+	}
+	direction, comparison := "ASC", ">"
+	if sort.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	orderArgs := make([]interface{}, 0)
+	if sort.Field == repository.SortRelevance {
+		if len(words) == 0 || filter.Cursor != nil {
+			return nil, errors.New("relevance order requires a search query and offset pagination")
+		}
+		sortColumn, orderArgs = relevance(words)
+	}
+
+	var limit string
+	if filter.Cursor != nil {
+		where += fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", sortColumn, comparison)
+		args = append(args, filter.Cursor.Value, filter.Cursor.Value, filter.Cursor.ID)
+		args = append(args, orderArgs...)
+		limit = "LIMIT ?"
+		args = append(args, filter.Limit+1)
+	} else {
+		args = append(args, orderArgs...)
+		limit = "LIMIT ?, ?"
+		args = append(args, filter.Offset, filter.Limit+1)
+	}
+
+	query := fmt.Sprintf("SELECT %[1]s FROM feedbacks WHERE %[2]s ORDER BY %[3]s %[4]s, id %[4]s %[5]s", feedbackColumns, where, sortColumn, direction, limit)
+	results, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	for results.Next() {
+		feedback, err := scanFeedback(results)
+		if err != nil {
+			return nil, err
+		}
+
+		feedbacks = append(feedbacks, feedback)
+	}
+	err = results.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// one extra row is fetched to know whether the next page exists
+	if len(feedbacks) > filter.Limit {
+		feedbacks = feedbacks[:filter.Limit]
+		if len(feedbacks) > 0 && sort.Field != repository.SortRelevance {
+			last := feedbacks[len(feedbacks)-1]
+			response.NextCursor = repository.NewCursor(sort, last).Encode()
+		}
+	}
+	response.Items = feedbacks
+
+	return &response, nil
+}
+
+func (r *sqliteRepository) FindThread(ctx context.Context, id int, depth int, withTrashed bool) (*repository.FeedbackThread, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	trashedCondition := " AND deleted_at IS NULL"
+	if withTrashed {
+		trashedCondition = ""
+	}
+
+	rootQuery := "SELECT " + feedbackColumns + " FROM feedbacks WHERE id = ?" + trashedCondition
+	feedback, err := scanFeedback(r.db.QueryRowContext(ctx, rootQuery, id))
+	if err != nil {
+		return nil, err
+	}
+
+	root := &repository.FeedbackThread{Feedback: feedback, Replies: []*repository.FeedbackThread{}}
+	level := map[int]*repository.FeedbackThread{root.ID: root}
+
+	for i := 0; i < depth && len(level) > 0; i++ {
+		placeholders := make([]string, 0, len(level))
+		args := make([]interface{}, 0, len(level))
+		for parentId := range level {
+			placeholders = append(placeholders, "?")
+			args = append(args, parentId)
+		}
+
+		query := "SELECT " + feedbackColumns + " FROM feedbacks WHERE parent_id IN (" + strings.Join(placeholders, ", ") + ")" + trashedCondition + " ORDER BY created_at, id"
+		results, err := r.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		nextLevel := make(map[int]*repository.FeedbackThread)
+		for results.Next() {
+			reply, err := scanFeedback(results)
+			if err != nil {
+				results.Close()
+				return nil, err
+			}
+
+			node := &repository.FeedbackThread{Feedback: reply, Replies: []*repository.FeedbackThread{}}
+			parent := level[int(reply.ParentId.Int64)]
+			parent.Replies = append(parent.Replies, node)
+			nextLevel[reply.ID] = node
+		}
+		err = results.Err()
+		results.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		level = nextLevel
+	}
+
+	return root, nil
+}
+
+func (r *sqliteRepository) Create(ctx context.Context, request *repository.CreateRequest) (id int, err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	log.Println("transaction start")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			log.Println(err.Error())
+			log.Println("rollback")
+			tx.Rollback()
+			return
+		}
+		log.Println("commit")
+		err = tx.Commit()
+	}()
+
+	const queryTemplate string = "INSERT INTO feedbacks(parent_id, sender_uuid, sender_name, sender_avatar, receiver_uuid, receiver_name, receiver_avatar, offer_hash, offer_authorized, offer_owner_uuid, offer_type, offer_payment_method, offer_payment_method_slug, offer_fiat_code, offer_crypto_code, trade_hash, trade_fiat_amount_requested_in_usd, trade_status, message, feedback_type, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?, " + now + "), " + now + ")"
+
+	var parentId interface{}
+	if request.ParentId > 0 {
+		parentId = request.ParentId
+	}
+
+	var createdAt interface{}
+	if request.CreatedAt != "" {
+		createdAt = request.CreatedAt
+	}
+
+	// the schema requires the amount, a missing one is refused instead of stored empty
+	var amount interface{}
+	if request.TradeFiatAmountRequestedInUsd != "" {
+		amount = request.TradeFiatAmountRequestedInUsd
+	}
+
+	res, err := tx.ExecContext(
+		ctx,
+		queryTemplate,
+		parentId,
+		request.SenderUuid,
+		request.SenderName,
+		request.SenderAvatar,
+		request.ReceiverUuid,
+		request.ReceiverName,
+		request.ReceiverAvatar,
+		request.OfferHash,
+		request.OfferAthorized,
+		request.OfferOwnerUuid,
+		request.OfferType,
+		request.OfferPaymentMethod,
+		request.OfferPaymentMethodSlug,
+		request.OfferFiatCode,
+		request.OfferCryptoCode,
+		request.TradeHash,
+		amount,
+		request.TradeStatus,
+		request.Message,
+		request.FeedbackType,
+		createdAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	err = createStats(ctx, tx, request.ReceiverUuid)
+	if err != nil {
+		return 0, err
+	}
+
+	err = updateStats(ctx, tx, request.ReceiverUuid, request.FeedbackType, true)
+	if err != nil {
+		return 0, err
+	}
+
+	lastInsertedId, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(lastInsertedId), nil
+}
+
+func (r *sqliteRepository) Update(ctx context.Context, request *repository.UpdateRequest) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	log.Println("transaction start")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			log.Println(err.Error())
+			log.Println("rollback")
+			tx.Rollback()
+			return
+		}
+		log.Println("commit")
+		err = tx.Commit()
+	}()
+
+	const selectTemplate string = "SELECT " + feedbackColumns + " FROM feedbacks WHERE sender_uuid = ? AND receiver_uuid = ? AND offer_payment_method_slug = ? AND offer_fiat_code = ? AND deleted_at IS NULL ORDER BY id LIMIT 1"
+
+	var feedback *repository.Feedback
+	feedback, err = scanFeedback(tx.QueryRowContext(
+		ctx,
+		selectTemplate,
+		request.SenderUuid,
+		request.ReceiverUuid,
+		request.OfferPaymentMethodSlug,
+		request.OfferFiatCode,
+	))
+	if err != nil {
+		return err
+	}
+
+	return update(ctx, tx, feedback, request.Message, request.FeedbackType)
+}
+
+func (r *sqliteRepository) UpdateByID(ctx context.Context, id int, request *repository.PatchRequest) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	log.Println("transaction start")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			log.Println(err.Error())
+			log.Println("rollback")
+			tx.Rollback()
+			return
+		}
+		log.Println("commit")
+		err = tx.Commit()
+	}()
+
+	const selectTemplate string = "SELECT " + feedbackColumns + " FROM feedbacks WHERE id = ? AND deleted_at IS NULL"
+
+	var feedback *repository.Feedback
+	feedback, err = scanFeedback(tx.QueryRowContext(ctx, selectTemplate, id))
+	if err != nil {
+		return err
+	}
+
+	return update(ctx, tx, feedback, request.Message, request.FeedbackType)
+}
+
+// update writes the new message and type of a feedback read by tx and moves its stats
+func update(ctx context.Context, tx *sql.Tx, feedback *repository.Feedback, message string, feedbackType string) error {
+	if feedbackType != "" && feedbackType != feedback.FeedbackType {
+		err := updateStats(ctx, tx, feedback.ReceiverUuid, feedback.FeedbackType, false)
+		if err != nil {
+			return err
+		}
+
+		err = updateStats(ctx, tx, feedback.ReceiverUuid, feedbackType, true)
+		if err != nil {
+			return err
+		}
+
+		feedback.FeedbackType = feedbackType
+	}
+
+	if message != "" {
+		feedback.Message = message
+	}
+
+	const updateTemplate string = "UPDATE feedbacks SET message = ?, feedback_type = ?, updated_at = " + now + " WHERE id = ?"
+
+	_, err := tx.ExecContext(ctx, updateTemplate, feedback.Message, feedback.FeedbackType, feedback.ID)
+
+	return err
+}
+
+func (r *sqliteRepository) Delete(ctx context.Context, id int) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	log.Println("transaction start")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			log.Println(err.Error())
+			log.Println("rollback")
+			tx.Rollback()
+			return
+		}
+		log.Println("commit")
+		err = tx.Commit()
+	}()
+
+	const selectTemplate string = "SELECT " + feedbackColumns + " FROM feedbacks WHERE id = ? AND deleted_at IS NULL"
+
+	var feedback *repository.Feedback
+	feedback, err = scanFeedback(tx.QueryRowContext(ctx, selectTemplate, id))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE feedbacks SET deleted_at = "+now+", updated_at = "+now+" WHERE id = ?", feedback.ID)
+	if err != nil {
+		return err
+	}
+
+	return updateStats(ctx, tx, feedback.ReceiverUuid, feedback.FeedbackType, false)
+}
+
+func (r *sqliteRepository) Restore(ctx context.Context, id int) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	log.Println("transaction start")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			log.Println(err.Error())
+			log.Println("rollback")
+			tx.Rollback()
+			return
+		}
+		log.Println("commit")
+		err = tx.Commit()
+	}()
+
+	const selectTemplate string = "SELECT " + feedbackColumns + " FROM feedbacks WHERE id = ? AND deleted_at IS NOT NULL"
+
+	var feedback *repository.Feedback
+	feedback, err = scanFeedback(tx.QueryRowContext(ctx, selectTemplate, id))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE feedbacks SET deleted_at = NULL, updated_at = "+now+" WHERE id = ?", feedback.ID)
+	if err != nil {
+		return err
+	}
+
+	err = createStats(ctx, tx, feedback.ReceiverUuid)
+	if err != nil {
+		return err
+	}
+
+	return updateStats(ctx, tx, feedback.ReceiverUuid, feedback.FeedbackType, true)
+}
+
+func (r *sqliteRepository) DeleteOffer(ctx context.Context, request *repository.DeleteOfferRequest) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	const queryTemplate string = "UPDATE feedbacks SET offer_deleted_at = ?, updated_at = " + now + " WHERE offer_hash = ?"
+
+	_, err := r.db.ExecContext(ctx, queryTemplate, request.DeletedAt, request.OfferHash)
+
+	return err
+}
+
+func (r *sqliteRepository) ChangeTradeStatus(ctx context.Context, request *repository.ChangeTradeStatusRequest) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	const queryTemplate string = "UPDATE feedbacks SET trade_status = ?, updated_at = " + now + " WHERE trade_hash = ?"
+
+	_, err := r.db.ExecContext(ctx, queryTemplate, request.TradeStatus, request.TradeHash)
+
+	return err
+}
+
+func (r *sqliteRepository) GetStats(ctx context.Context, userUuid string) (*repository.Stats, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	const queryTemplate string = "SELECT positive, negative, initial FROM feedback_stats WHERE user_uuid = ?"
+
+	var positive, negative, initial int
+	err := r.db.QueryRowContext(ctx, queryTemplate, userUuid).Scan(&positive, &negative, &initial)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return repository.NewStats(userUuid, positive, negative, initial), nil
+}
+
+// RebuildStats recounts the counters of every user from the feedbacks left
+// after soft deletes. Each batch of users gets its own transaction and
+// timeout, so the writers wait for one batch at most.
+func (r *sqliteRepository) RebuildStats(ctx context.Context, request *repository.RebuildStatsRequest) ([]*repository.StatsDiff, error) {
+	batchSize := request.BatchSize
+	if batchSize <= 0 {
+		batchSize = repository.DefaultStatsBatchSize
+	}
+
+	diffs := make([]*repository.StatsDiff, 0)
+	after := ""
+	for {
+		users, err := r.statsUsers(ctx, after, batchSize)
+		if err != nil {
+			return diffs, err
+		}
+		if len(users) == 0 {
+			return diffs, nil
+		}
+
+		batch, err := r.rebuildStats(ctx, users, request.DryRun)
+		if err != nil {
+			return diffs, err
+		}
+		diffs = append(diffs, batch...)
+
+		after = users[len(users)-1]
+	}
+}
+
+// statsUsers lists the next users having feedbacks or counters ordered by uuid
+func (r *sqliteRepository) statsUsers(ctx context.Context, after string, limit int) ([]string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT user_uuid FROM (SELECT receiver_uuid AS user_uuid FROM feedbacks UNION SELECT user_uuid FROM feedback_stats) AS users"
+	args := make([]interface{}, 0)
+	if after != "" {
+		query += " WHERE user_uuid > ?"
+		args = append(args, after)
+	}
+	query += " ORDER BY user_uuid LIMIT ?"
+	args = append(args, limit)
+
+	results, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	users := make([]string, 0, limit)
+	for results.Next() {
+		var userUuid string
+		err = results.Scan(&userUuid)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, userUuid)
+	}
+
+	return users, results.Err()
+}
+
+// rebuildStats fixes the counters of the users, the single connection keeps
+// the writers out until the transaction ends
+func (r *sqliteRepository) rebuildStats(ctx context.Context, users []string, dryRun bool) (diffs []*repository.StatsDiff, err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	log.Println("transaction start")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			log.Println(err.Error())
+			log.Println("rollback")
+			tx.Rollback()
+			return
+		}
+		log.Println("commit")
+		err = tx.Commit()
+	}()
+
+	placeholders := "?" + strings.Repeat(", ?", len(users)-1)
+	args := make([]interface{}, 0, len(users))
+	for _, userUuid := range users {
+		args = append(args, userUuid)
+	}
+
+	results, err := tx.QueryContext(ctx, "SELECT user_uuid, positive, negative FROM feedback_stats WHERE user_uuid IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := scanStatsCounts(results)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err = tx.QueryContext(ctx, "SELECT receiver_uuid, COUNT(CASE WHEN feedback_type = 'POSITIVE' THEN 1 END), COUNT(CASE WHEN feedback_type = 'NEGATIVE' THEN 1 END) FROM feedbacks WHERE deleted_at IS NULL AND receiver_uuid IN ("+placeholders+") GROUP BY receiver_uuid", args...)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := scanStatsCounts(results)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs = repository.NewStatsDiffs(users, stored, expected)
+	if dryRun {
+		return diffs, nil
+	}
+
+	const queryTemplate string = "INSERT INTO feedback_stats (user_uuid, positive, negative) VALUES(?, ?, ?) ON CONFLICT (user_uuid) DO UPDATE SET positive = excluded.positive, negative = excluded.negative"
+
+	for _, diff := range diffs {
+		_, err = tx.ExecContext(ctx, queryTemplate, diff.UserUuid, diff.Expected.Positive, diff.Expected.Negative)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return diffs, nil
+}
+
+func scanStatsCounts(results *sql.Rows) (map[string]repository.StatsCounts, error) {
+	defer results.Close()
+
+	counts := make(map[string]repository.StatsCounts)
+	for results.Next() {
+		var userUuid string
+		var userCounts repository.StatsCounts
+		err := results.Scan(&userUuid, &userCounts.Positive, &userCounts.Negative)
+		if err != nil {
+			return nil, err
+		}
+		counts[userUuid] = userCounts
+	}
+
+	return counts, results.Err()
+}
+
+// statsColumns whitelists the feedback_stats counters a feedback type maps to
+var statsColumns = map[string]string{
+	"POSITIVE": "positive",
+	"NEGATIVE": "negative",
+}
+
+func createStats(ctx context.Context, tx *sql.Tx, userUuid string) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO feedback_stats (user_uuid) VALUES(?) ON CONFLICT (user_uuid) DO NOTHING", userUuid)
+
+	return err
+}
+
+func updateStats(ctx context.Context, tx *sql.Tx, userUuid string, feedbackType string, isIncrease bool) error {
+	column, ok := statsColumns[feedbackType]
+	if !ok {
+		return fmt.Errorf("unknown feedback type %q", feedbackType)
+	}
+
+	var queryTemplate string
+	if isIncrease {
+		queryTemplate = "UPDATE feedback_stats SET %[1]s = %[1]s + 1 WHERE user_uuid = ?"
+	} else {
+		queryTemplate = "UPDATE feedback_stats SET %[1]s = %[1]s - 1 WHERE user_uuid = ?"
+	}
+
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(queryTemplate, column), userUuid)
+
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFeedback(row scanner) (*repository.Feedback, error) {
+	var feedback repository.Feedback
+	err := row.Scan(
+		&feedback.ID,
+		&feedback.ParentId,
+		&feedback.SenderUuid,
+		&feedback.SenderName,
+		&feedback.SenderAvatar,
+		&feedback.ReceiverUuid,
+		&feedback.ReceiverName,
+		&feedback.ReceiverAvatar,
+		&feedback.OfferHash,
+		&feedback.OfferAthorized,
+		&feedback.OfferOwnerUuid,
+		&feedback.OfferType,
+		&feedback.OfferPaymentMethod,
+		&feedback.OfferPaymentMethodSlug,
+		&feedback.OfferFiatCode,
+		&feedback.OfferCryptoCode,
+		&feedback.OfferDeletedAt,
+		&feedback.TradeHash,
+		&feedback.TradeFiatAmountRequestedInUsd,
+		&feedback.TradeStatus,
+		&feedback.Message,
+		&feedback.FeedbackType,
+		&feedback.CreatedAt,
+		&feedback.UpdatedAt,
+		&feedback.DeletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &feedback, nil
+}
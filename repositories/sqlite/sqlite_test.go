@@ -0,0 +1,88 @@
+package sqliterepository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	repository "feedback-service-go/repositories"
+	"feedback-service-go/repositories/migrations"
+	"feedback-service-go/repositories/repositorytest"
+)
+
+var ctx = context.Background()
+
+func newTestRepository(t *testing.T) repository.Repository {
+	return openMigrated(t, filepath.Join(t.TempDir(), "feedbacks.db"))
+}
+
+func openMigrated(t *testing.T, path string) repository.Repository {
+	repo, err := Open(path, defaultQueryTimeout)
+	if err != nil {
+		t.Fatalf("can't open the database: %v", err)
+	}
+	t.Cleanup(repo.Close)
+
+	migrator, err := migrations.New(repo.GetDB(), "sqlite")
+	if err != nil {
+		t.Fatalf("can't load the migrations: %v", err)
+	}
+	_, err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("can't migrate the database: %v", err)
+	}
+
+	return repo
+}
+
+func TestRepositorySuite(t *testing.T) {
+	repositorytest.RunSuite(t, newTestRepository)
+}
+
+func TestOpenKeepsData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedbacks.db")
+
+	repo := openMigrated(t, path)
+	id, err := repo.Create(ctx, repositorytest.NewCreateRequest("message"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	repo.Close()
+
+	repo, err = Open(path, defaultQueryTimeout)
+	if err != nil {
+		t.Fatalf("can't reopen the database: %v", err)
+	}
+	defer repo.Close()
+
+	feedback, err := repo.FindByID(ctx, id)
+	if err != nil {
+		t.Fatalf("FindByID failed: %v", err)
+	}
+	if feedback.Message != "message" {
+		t.Errorf("expected the stored message, got %q", feedback.Message)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for _, message := range []string{"fast and fair", "slow", "Fast, fast, FAST"} {
+		_, err := repo.Create(ctx, repositorytest.NewCreateRequest(message))
+		if err != nil {
+			t.Fatalf("Create(%q) failed: %v", message, err)
+		}
+	}
+
+	response, err := repo.Find(ctx, &repository.RequestFilter{
+		Query: "fast fair",
+		Sort:  &repository.Sort{Field: repository.SortRelevance, Desc: true},
+		Limit: 10,
+	})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if len(response.Items) != 2 || response.Items[0].Message != "Fast, fast, FAST" || response.Items[1].Message != "fast and fair" {
+		t.Errorf("expected the two fast feedbacks by relevance, got %+v", response.Items)
+	}
+}
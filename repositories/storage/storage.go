@@ -0,0 +1,154 @@
+// Package storage opens the Repository backend the service is configured with
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	repository "feedback-service-go/repositories"
+	memoryrepository "feedback-service-go/repositories/memory"
+	"feedback-service-go/repositories/migrations"
+	mysqlrepository "feedback-service-go/repositories/mysql"
+	postgresrepository "feedback-service-go/repositories/postgres"
+	sqliterepository "feedback-service-go/repositories/sqlite"
+)
+
+// defaultQueryTimeout bounds every repository call unless DB_QUERY_TIMEOUT says otherwise
+const defaultQueryTimeout = 5 * time.Second
+
+const (
+	BackendMysql    = "mysql"
+	BackendPostgres = "postgres"
+	BackendSqlite   = "sqlite"
+	BackendMemory   = "memory"
+)
+
+// New opens the backend named by the STORAGE env var:
+//   - empty or "mysql" for MySQL configured by the DB_* env vars
+//   - a "postgres://" or "postgresql://" URL for PostgreSQL
+//   - a "sqlite://" URL with the path of the database file, e.g. "sqlite:///var/lib/feedbacks.db"
+//   - "memory" for the in-memory one, which loses everything on exit
+//
+// DB_MIGRATIONS set to "check", the default, refuses to open a database with
+// pending migrations, "up" applies them first and "skip" trusts the schema.
+func New() (repository.Repository, error) {
+	// the settings may come from the environment only, so a missing .env is fine
+	godotenv.Load(".env")
+
+	storage := os.Getenv("STORAGE")
+	repo, err := Open(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	err = prepareSchema(context.Background(), storage, repo, os.Getenv("DB_MIGRATIONS"))
+	if err != nil {
+		repo.Close()
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// Backend names the kind of the storage, an empty string stands for an unknown one
+func Backend(storage string) string {
+	switch {
+	case storage == "" || storage == "mysql":
+		return BackendMysql
+	case storage == "memory":
+		return BackendMemory
+	case strings.HasPrefix(storage, "postgres://") || strings.HasPrefix(storage, "postgresql://"):
+		return BackendPostgres
+	case strings.HasPrefix(storage, "sqlite://"):
+		return BackendSqlite
+	default:
+		return ""
+	}
+}
+
+func Open(storage string) (repository.Repository, error) {
+	backend := Backend(storage)
+	if backend == "" {
+		return nil, fmt.Errorf("unknown storage %q", redact(storage))
+	}
+	if backend == BackendMemory {
+		return memoryrepository.New(), nil
+	}
+
+	queryTimeout, err := getQueryTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	switch backend {
+	case BackendMysql:
+		return mysqlrepository.Open(mysqlrepository.DSN(), queryTimeout)
+	case BackendPostgres:
+		return postgresrepository.Open(storage, queryTimeout)
+	default:
+		return sqliterepository.Open(strings.TrimPrefix(storage, "sqlite://"), queryTimeout)
+	}
+}
+
+// NewMigrator returns the migrations of the storage run against the database
+// of repo, nil for the in-memory storage which has no schema
+func NewMigrator(storage string, repo repository.Repository) (*migrations.Migrator, error) {
+	backend := Backend(storage)
+	if backend == BackendMemory {
+		return nil, nil
+	}
+
+	return migrations.New(repo.GetDB(), backend)
+}
+
+func prepareSchema(ctx context.Context, storage string, repo repository.Repository, mode string) error {
+	switch mode {
+	case "skip":
+		return nil
+	case "":
+		mode = "check"
+	case "check", "up":
+	default:
+		return fmt.Errorf("unknown DB_MIGRATIONS %q, expected check, up or skip", mode)
+	}
+
+	migrator, err := NewMigrator(storage, repo)
+	if err != nil || migrator == nil {
+		return err
+	}
+
+	if mode == "check" {
+		return migrator.Check(ctx)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("applied %d migrations", len(applied))
+
+	return nil
+}
+
+func getQueryTimeout() (time.Duration, error) {
+	inputTimeout := os.Getenv("DB_QUERY_TIMEOUT")
+	if inputTimeout == "" {
+		return defaultQueryTimeout, nil
+	}
+
+	return time.ParseDuration(inputTimeout)
+}
+
+// redact keeps credentials of a mistyped URL out of the logs
+func redact(storage string) string {
+	if i := strings.Index(storage, "://"); i >= 0 {
+		return storage[:i+3] + "..."
+	}
+	return storage
+}
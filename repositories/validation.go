@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"regexp"
+	"time"
+)
+
+// DateTimeLayout is the format of every timestamp accepted in the requests
+const DateTimeLayout = "2006-01-02 15:04:05"
+
+var (
+	FeedbackTypes = []string{"POSITIVE", "NEGATIVE"}
+	OfferTypes    = []string{"BUY", "SELL"}
+	TradeStatuses = []string{"RELEASED", "CANCELLED", "DISPUTED"}
+	FiatCodes     = []string{"USD", "EUR", "RUB", "PLN", "CNY", "VES", "NGN"}
+)
+
+var (
+	uuidRegexp   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hashRegexp   = regexp.MustCompile(`^[0-9A-Za-z]{11}$`)
+	amountRegexp = regexp.MustCompile(`^[0-9]{1,8}(\.[0-9]{1,2})?$`)
+)
+
+func IsUuid(value string) bool {
+	return uuidRegexp.MatchString(value)
+}
+
+// IsHash checks the format of offer and trade hashes
+func IsHash(value string) bool {
+	return hashRegexp.MatchString(value)
+}
+
+// IsAmount checks that the value fits into DECIMAL(10, 2)
+func IsAmount(value string) bool {
+	return amountRegexp.MatchString(value)
+}
+
+// IsDateTime accepts DateTimeLayout with optional fractional seconds
+func IsDateTime(value string) bool {
+	_, err := time.Parse(DateTimeLayout, value)
+	return err == nil
+}
+
+func IsOneOf(value string, list []string) bool {
+	for _, item := range list {
+		if value == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFieldFormats(t *testing.T) {
+	for _, check := range []struct {
+		name     string
+		valid    func(string) bool
+		accepted []string
+		rejected []string
+	}{
+		{"IsUuid", IsUuid, []string{"807a51d6-a81b-4b66-9596-5b17ea26b136", "807A51D6-A81B-4B66-9596-5B17EA26B136"}, []string{"", "807a51d6a81b4b6695965b17ea26b136", "807a51d6-a81b-4b66-9596-5b17ea26b13z"}},
+		{"IsHash", IsHash, []string{"ksO3jso7aDi", "12345678901"}, []string{"", "ksO3jso7aD", "ksO3jso7aDi1", "ksO3jso7aD!"}},
+		{"IsAmount", IsAmount, []string{"0", "320.12", "320.1", "99999999.99"}, []string{"", "-1", "320.123", "100000000", "1e3", ".5"}},
+		{"IsDateTime", IsDateTime, []string{"2021-09-06 05:01:43"}, []string{"", "2021-09-06", "2021-09-06T05:01:43", "2021-13-06 05:01:43"}},
+	} {
+		for _, value := range check.accepted {
+			if !check.valid(value) {
+				t.Errorf("%s(%q) expected to be true", check.name, value)
+			}
+		}
+		for _, value := range check.rejected {
+			if check.valid(value) {
+				t.Errorf("%s(%q) expected to be false", check.name, value)
+			}
+		}
+	}
+}
+
+func TestCreateRequestValidate(t *testing.T) {
+	if errs := newCreateRequest().Validate(); len(errs) > 0 {
+		t.Fatalf("expected a valid request, got %v", errs)
+	}
+
+	for field, spoil := range map[string]func(request *CreateRequest){
+		"parent_id":                          func(request *CreateRequest) { request.ParentId = -1 },
+		"sender_uuid":                        func(request *CreateRequest) { request.SenderUuid = "sender" },
+		"receiver_uuid":                      func(request *CreateRequest) { request.ReceiverUuid = request.SenderUuid },
+		"offer_owner_uuid":                   func(request *CreateRequest) { request.OfferOwnerUuid = "" },
+		"offer_hash":                         func(request *CreateRequest) { request.OfferHash = "short" },
+		"trade_hash":                         func(request *CreateRequest) { request.TradeHash = "" },
+		"receiver_name":                      func(request *CreateRequest) { request.ReceiverName = strings.Repeat("я", 17) },
+		"offer_payment_method_slug":          func(request *CreateRequest) { request.OfferPaymentMethodSlug = "" },
+		"offer_type":                         func(request *CreateRequest) { request.OfferType = "sell" },
+		"offer_fiat_code":                    func(request *CreateRequest) { request.OfferFiatCode = "GBP" },
+		"trade_fiat_amount_requested_in_usd": func(request *CreateRequest) { request.TradeFiatAmountRequestedInUsd = "320.123" },
+		"trade_status":                       func(request *CreateRequest) { request.TradeStatus = "PAID" },
+		"message":                            func(request *CreateRequest) { request.Message = "" },
+		"feedback_type":                      func(request *CreateRequest) { request.FeedbackType = "NEUTRAL" },
+		"created_at":                         func(request *CreateRequest) { request.CreatedAt = "yesterday" },
+	} {
+		request := newCreateRequest()
+		spoil(request)
+
+		errs := request.Validate()
+		if len(errs) != 1 || errs.Get(field) == "" {
+			t.Errorf("expected only the %s field to be rejected, got %v", field, errs)
+		}
+	}
+
+	// the names are limited by chars, not bytes
+	request := newCreateRequest()
+	request.ReceiverName = strings.Repeat("я", 16)
+	if errs := request.Validate(); len(errs) > 0 {
+		t.Errorf("expected 16 cyrillic chars to fit into the receiver_name, got %v", errs)
+	}
+}
+
+func TestUpdateRequestValidate(t *testing.T) {
+	request := UpdateRequest{
+		SenderUuid:             "807a51d6-a81b-4b66-9596-5b17ea26b136",
+		ReceiverUuid:           "807a51d6-a81b-4b66-9596-5b17ea26b137",
+		OfferPaymentMethodSlug: "paypal_slug",
+		OfferFiatCode:          "RUB",
+		FeedbackType:           "NEGATIVE",
+	}
+	if errs := request.Validate(); len(errs) > 0 {
+		t.Fatalf("expected a valid request, got %v", errs)
+	}
+
+	errs := (&UpdateRequest{FeedbackType: "NEUTRAL"}).Validate()
+	expected := "map[feedback_type:[The feedback_type field must be one of POSITIVE, NEGATIVE!] offer_fiat_code:[The offer_fiat_code field must be one of USD, EUR, RUB, PLN, CNY, VES, NGN!] offer_payment_method_slug:[The offer_payment_method_slug field is required!] receiver_uuid:[The receiver_uuid field is required and must be a UUID!] sender_uuid:[The sender_uuid field is required and must be a UUID!]]"
+	if fmt.Sprint(errs) != expected {
+		t.Errorf("expected %s, got %v", expected, errs)
+	}
+}
+
+func TestDeleteOfferRequestValidate(t *testing.T) {
+	if errs := (&DeleteOfferRequest{OfferHash: "ksO3jso7aDi", DeletedAt: "2021-09-06 05:01:43"}).Validate(); len(errs) > 0 {
+		t.Fatalf("expected a valid request, got %v", errs)
+	}
+
+	errs := (&DeleteOfferRequest{OfferHash: "ksO3jso7aD"}).Validate()
+	if len(errs) != 2 || errs.Get("offer_hash") == "" || errs.Get("deleted_at") == "" {
+		t.Errorf("expected the offer_hash and deleted_at fields to be rejected, got %v", errs)
+	}
+}
+
+func TestChangeTradeStatusRequestValidate(t *testing.T) {
+	if errs := (&ChangeTradeStatusRequest{TradeHash: "isO9AlIU8s2", TradeStatus: "DISPUTED"}).Validate(); len(errs) > 0 {
+		t.Fatalf("expected a valid request, got %v", errs)
+	}
+
+	errs := (&ChangeTradeStatusRequest{TradeHash: "isO9AlIU8s2", TradeStatus: "disputed"}).Validate()
+	if len(errs) != 1 || errs.Get("trade_status") == "" {
+		t.Errorf("expected the trade_status field to be rejected, got %v", errs)
+	}
+}
+
+func newCreateRequest() *CreateRequest {
+	return &CreateRequest{
+		SenderUuid:                    "807a51d6-a81b-4b66-9596-5b17ea26b136",
+		SenderName:                    "sender#1",
+		SenderAvatar:                  "sender#1 avatar",
+		ReceiverUuid:                  "807a51d6-a81b-4b66-9596-5b17ea26b137",
+		ReceiverName:                  "receiver#1",
+		ReceiverAvatar:                "receiver#1 avatar",
+		OfferHash:                     "ksO3jso7aDi",
+		OfferAthorized:                true,
+		OfferOwnerUuid:                "807a51d6-a81b-4b66-9596-5b17ea26b138",
+		OfferType:                     "SELL",
+		OfferPaymentMethod:            "PayPal",
+		OfferPaymentMethodSlug:        "paypal_slug",
+		OfferFiatCode:                 "RUB",
+		OfferCryptoCode:               "BTC",
+		TradeHash:                     "isO9AlIU8s2",
+		TradeFiatAmountRequestedInUsd: "320.12",
+		TradeStatus:                   "RELEASED",
+		Message:                       "message",
+		FeedbackType:                  "POSITIVE",
+		CreatedAt:                     "2021-09-06 05:01:43",
+	}
+}